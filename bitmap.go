@@ -0,0 +1,48 @@
+package main
+
+import "math/bits"
+
+// bitmap is a set of positive integers, used for deleted documents
+// bit i of the word i/64 is set when i is in the set
+type bitmap []uint64
+
+// has returns wether i is in the bitmap
+func (b bitmap) has(i int) bool {
+	return i/64 < len(b) && b[i/64]&(1<<uint(i%64)) != 0
+}
+
+// set adds i to the bitmap, it must not be used on a shared bitmap
+func (b *bitmap) set(i int) {
+	for len(*b) <= i/64 {
+		*b = append(*b, 0)
+	}
+	(*b)[i/64] |= 1 << uint(i%64)
+}
+
+// with returns a copy of the bitmap with i added
+func (b bitmap) with(i int) bitmap {
+	c := make(bitmap, len(b))
+	copy(c, b)
+	c.set(i)
+	return c
+}
+
+// count returns the number of elements in the bitmap
+func (b bitmap) count() int {
+	var c int
+	for _, w := range b {
+		c += bits.OnesCount64(w)
+	}
+	return c
+}
+
+// each calls f for all elements of the bitmap in increasing order
+func (b bitmap) each(f func(i int)) {
+	for j, w := range b {
+		for w != 0 {
+			i := bits.TrailingZeros64(w)
+			f(j*64 + i)
+			w &= w - 1
+		}
+	}
+}
@@ -8,8 +8,6 @@ package main
 import (
 	"log"
 	"strings"
-
-	"github.com/surgebase/porter2"
 )
 
 type operator int
@@ -50,10 +48,10 @@ type WordQuery struct {
 }
 
 func (w WordQuery) evaluate(s *Search, prec []Ref) []Ref {
-	if len(w.w) > 3 {
-		w.w = porter2.Stem(w.w)
+	if term, ok := fieldTerm(w.w); ok {
+		return s.get(term)
 	}
-	return s.Index.get(w.w)
+	return s.get(s.analyze(w.w))
 }
 
 func (w WordQuery) isNot() bool { return false }
@@ -175,9 +173,10 @@ func remove(refs1, refs2 []Ref) []Ref {
 
 // BooleanQuery parses a query tring it's best to interpret it as a boolean query
 // it will fail silently and returns empty results if it can't
+// year ranges of the query filter the results
 func BooleanQuery(s *Search, input string) (results []Ref) {
 	// split the words == really basic parsing of the query
-	words := strings.FieldsFunc(input, splitter)
+	ranges, words := yearRanges(queryWords(input))
 
 	// query interpretation using Shunting-yard
 	// query is the output queue
@@ -257,7 +256,7 @@ func BooleanQuery(s *Search, input string) (results []Ref) {
 	if len(query) != 1 {
 		log.Println("error when processing bool query")
 	} else {
-		results = query[0].evaluate(s, make([]Ref, 0))
+		results = s.filterDates(query[0].evaluate(s, make([]Ref, 0)), ranges)
 	}
 	return results
 }
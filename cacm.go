@@ -3,7 +3,10 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"io"
+	"strconv"
+	"strings"
 	"unicode"
 )
 
@@ -17,6 +20,7 @@ const (
 	keyWords
 	publication
 	authors
+	citations
 	other
 )
 
@@ -34,6 +38,8 @@ func identToField(ident string) field {
 		return publication
 	case ".A":
 		return authors
+	case ".X":
+		return citations
 	default:
 		return other
 	}
@@ -48,23 +54,43 @@ type CACMScanner struct {
 	doc        *Document
 	id         int
 	trie       *Root
+	// month is the last word read in the publication field
+	month string
+	// raw is the text read since the start of the document, lastSize the size of the last rune read
+	raw      bytes.Buffer
+	lastSize int
+	// lineStart is true when the next character starts a line
+	// field identifiants are only recognized there, elsewhere a dot is a punctuation
+	lineStart bool
 }
 
 // NewCACMScanner create a CACMScanner from an io reader
 func NewCACMScanner(r io.Reader, cw map[string]bool, trie *Root) *CACMScanner {
-	return &CACMScanner{r: bufio.NewReader(r), commonWord: cw, trie: trie, doc: newDocument()}
+	return &CACMScanner{r: bufio.NewReader(r), commonWord: cw, trie: trie, doc: newDocument(), lineStart: true}
 }
 
 func (s *CACMScanner) read() rune {
-	ch, _, err := s.r.ReadRune()
+	ch, size, err := s.r.ReadRune()
 	if err != nil {
+		s.lastSize = 0
 		return eof
 	}
+	s.raw.WriteRune(ch)
+	s.lastSize = size
 	return ch
 }
 
 func (s *CACMScanner) unread() {
 	s.r.UnreadRune()
+	s.raw.Truncate(s.raw.Len() - s.lastSize)
+	s.lastSize = 0
+}
+
+// readLine reads the rest of the line
+func (s *CACMScanner) readLine() string {
+	line, _ := s.r.ReadString('\n')
+	s.raw.WriteString(line)
+	return line
 }
 
 func (s *CACMScanner) peek() rune {
@@ -77,7 +103,9 @@ func (s *CACMScanner) peek() rune {
 }
 
 // scanWhitespace scans the next whitespace
-func (s *CACMScanner) scanWhitespace() {
+// it returns true if a new line has been started
+func (s *CACMScanner) scanWhitespace() bool {
+	var newLine bool
 	for {
 		ch := s.read()
 		if ch == eof {
@@ -86,21 +114,66 @@ func (s *CACMScanner) scanWhitespace() {
 			s.unread()
 			break
 		}
+		if ch == '\n' {
+			newLine = true
+		}
 	}
+	return newLine
 }
 
-// scanIdentifiant scans the next identifiant
-// it returns "token" false if it's not actually an identifiant
+// scanIdentifiant scans the next identifiant, the dot being already read
+// it returns false, without reading anything, if it's not actually an identifiant
 func (s *CACMScanner) scanIdentifiant() (string, bool) {
-	ch := s.read()
-	tmp := s.peek()
+	// bufio only allows to unread one rune, so the two next bytes are peeked
+	next, _ := s.r.Peek(2)
 	// we check it's really an identifiant, only one character and it's a letter
-	if !unicode.IsSpace(tmp) || ch < 'A' || ch > 'Z' {
-		s.unread()
-		s.unread()
-		return s.scanToken(), false
+	if len(next) == 0 || next[0] < 'A' || next[0] > 'Z' {
+		return "", false
+	}
+	if len(next) == 2 && !unicode.IsSpace(rune(next[1])) {
+		return "", false
+	}
+	s.read()
+	return "." + string(next[0]), true
+}
+
+// scanAuthor reads the rest of an author line and adds the author to the document
+func (s *CACMScanner) scanAuthor() {
+	line := s.readLine()
+	if term := authorTerm(line); term != "" {
+		s.doc.addTerm(term)
+	}
+}
+
+// scanDate reads a token of the publication field, as in "CACM December, 1958"
+// the year is indexed as a term and the date stored with the month preceding it
+func (s *CACMScanner) scanDate(lit string) {
+	term := yearTerm(lit)
+	if term == "" {
+		s.month = lit
+		return
+	}
+	s.doc.addTerm(term)
+	year, _ := strconv.Atoi(lit)
+	s.doc.Date = documentDate(s.month, year)
+}
+
+// scanLink reads the rest of a link line, "number type number"
+// links of type 5 are citations, written in the records of both documents
+// so only the ones to older documents, with a lower number, are kept as cited by the document
+// the number cited is its external id, resolved once all documents are read
+func (s *CACMScanner) scanLink() {
+	line := s.readLine()
+	fields := strings.Fields(line)
+	if len(fields) != 3 || fields[1] != "5" {
+		return
 	}
-	return "." + string(ch), true
+	no, err := strconv.Atoi(fields[0])
+	own, _ := strconv.Atoi(s.doc.External)
+	if err != nil || no >= own {
+		return
+	}
+	s.doc.Cites = append(s.doc.Cites, strconv.Itoa(no))
 }
 
 func (s *CACMScanner) scanToken() string {
@@ -142,51 +215,108 @@ func (s *CACMScanner) addToken(lit string) {
 		return
 	}
 	s.doc.addWord(lit)
+	// and also as terms of their field
+	s.doc.addTerm(s.fieldPrefix() + stem(lit))
+}
+
+// fieldPrefix returns the prefix of the terms of the field being read
+func (s *CACMScanner) fieldPrefix() string {
+	switch s.field {
+	case title:
+		return titlePrefix
+	case keyWords:
+		return keywordPrefix
+	default:
+		return abstractPrefix
+	}
 }
 
 // Scan reads the next "word"
-func (s *CACMScanner) Scan(c chan metadata) {
+// once ctx is cancelled it stops at the start of the next document
+func (s *CACMScanner) Scan(ctx context.Context, c chan metadata) {
 	for {
 		ch := s.read()
 		switch {
 		case unicode.IsSpace(ch):
 			s.unread()
-			s.scanWhitespace()
+			s.lineStart = s.scanWhitespace()
 			if s.field == title {
 				s.title.WriteRune(' ')
 			}
-		case ch == '.':
+			continue
+		case ch == '.' && s.lineStart:
 			lit, isIdent := s.scanIdentifiant()
 			if !isIdent {
+				s.writeTitle(ch)
+				break
 			}
 			s.field = identToField(lit)
 			if s.field == id {
+				if ctx.Err() != nil {
+					close(c)
+					return
+				}
 				if s.id != 0 {
-					// Add the previous document
-					s.doc.Title = s.title.String()
-					// Send the document
-					s.trie.addDoc(s.doc)
-					c <- metadataFromDoc(s.doc)
+					// the ".I" read starts the next document
+					s.send(c, s.raw.Bytes()[:s.raw.Len()-len(lit)])
 				}
 				// Reset the document
 				s.doc.reset()
 				s.title.Reset()
+				s.month = ""
+				s.raw.Reset()
+				s.raw.WriteString(lit)
 				s.id++
 			}
+		case tokenMember(ch) && s.field == authors && s.lineStart:
+			// authors are written one per line, as "Surname, Initials"
+			s.unread()
+			s.scanAuthor()
+			s.lineStart = true
+			continue
+		case tokenMember(ch) && s.field == citations && s.lineStart:
+			s.unread()
+			s.scanLink()
+			s.lineStart = true
+			continue
 		case tokenMember(ch):
 			s.unread()
 			lit := s.scanToken()
 			if s.field == title || s.field == summary || s.field == keyWords {
 				s.addToken(lit)
+			} else if s.field == publication {
+				s.scanDate(lit)
+			} else if s.field == id {
+				// the number of the document is its external id
+				s.doc.External = lit
 			}
 		case ch == eof:
-			// Add the previous document
-			s.doc.Title = s.title.String()
-			// Send the document
-			s.trie.addDoc(s.doc)
-			c <- metadataFromDoc(s.doc)
+			if s.id != 0 {
+				s.send(c, s.raw.Bytes())
+			}
 			close(c)
 			return
+		default:
+			// punctuation is only kept in titles
+			s.writeTitle(ch)
 		}
+		s.lineStart = false
+	}
+}
+
+// send adds the document read to the index and sends its metadata
+// text is the raw text of the document, it's copied as the buffer is reused
+func (s *CACMScanner) send(c chan metadata, text []byte) {
+	s.doc.Title = s.title.String()
+	s.doc.Text = append([]byte(nil), text...)
+	s.doc.Read = len(text)
+	s.trie.addDoc(s.doc)
+	c <- metadataFromDoc(s.doc)
+}
+
+// writeTitle adds a character to the title, if it's the field being read
+func (s *CACMScanner) writeTitle(ch rune) {
+	if s.field == title {
+		s.title.WriteRune(ch)
 	}
 }
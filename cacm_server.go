@@ -15,12 +15,32 @@ type cacmDoc struct {
 	W string
 	A string
 	K string
+	// Cites are the documents cited by the document, CitedBy the ones citing it
+	Cites   []Result
+	CitedBy []Result
 }
 
-// getCACMDoc returns a cacmDoc from parsing the cacm.all file
-func getCACMDoc(index int) (cacmDoc, error) {
+// cacmDocument returns a cacmDoc from the document store of the index
+// indexes built before the store existed fall back to reading the source of the corpus
+func cacmDocument(s *Search, index int) (cacmDoc, error) {
+	text, err := s.document(index)
+	if err != nil {
+		source := cacmFile
+		if s.corpus != nil {
+			source = s.corpus.Source()
+		}
+		return getCACMDoc(source, index)
+	}
+	buf := bufio.NewReader(bytes.NewReader(text))
+	// skip the .I line
+	buf.ReadString('\n')
+	return ParseCACMDoc(buf)
+}
+
+// getCACMDoc returns a cacmDoc from parsing the cacm.all formatted file source
+func getCACMDoc(source string, index int) (cacmDoc, error) {
 	index++
-	file, err := os.Open(cacmFile)
+	file, err := os.Open(source)
 	if err != nil {
 		return cacmDoc{}, err
 	}
@@ -54,11 +74,12 @@ func ParseCACMDoc(buf *bufio.Reader) (cacmDoc, error) {
 	var tmp bytes.Buffer
 	for {
 		line, err := buf.ReadString('\n')
-		if err != nil {
-			// end of file, we are returning the last doc
-			return doc, nil
+		end := err != nil
+		if end {
+			// end of file, the last field is added before returning the last doc
+			tmp.WriteString(line)
 		}
-		if line[0] == '.' {
+		if end || line[0] == '.' {
 			// add to previous state
 			switch state {
 			case title:
@@ -72,6 +93,9 @@ func ParseCACMDoc(buf *bufio.Reader) (cacmDoc, error) {
 			case authors:
 				doc.A = tmp.String()
 			}
+			if end {
+				return doc, nil
+			}
 			// get new state
 			state = identToField(line[:2])
 			tmp.Reset()
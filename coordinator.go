@@ -0,0 +1,237 @@
+// Coordinator.go implements the coordinator mode of the server
+// it doesn't hold any index, queries are sent over http to all shards
+// vectorial queries are done in two steps: first the document frequencies of the terms
+// are gathered from all shards to compute global idf, then each shard returns
+// its best results scored with those statistics, and the lists are merged
+// shards which don't answer are skipped and reported in the answer
+// the pages of documents are asked to the shards in turn, the one holding the document serves it
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/expvar"
+)
+
+const (
+	// shardTimeout is the time after which a shard is considered down
+	shardTimeout = 2 * time.Second
+)
+
+// Coordinator sends queries to a set of shards
+type Coordinator struct {
+	// Shards are the base url of the shards, e.g http://localhost:8081
+	Shards []string
+	client *http.Client
+}
+
+// NewCoordinator returns a coordinator for the shards at urls
+func NewCoordinator(urls []string) *Coordinator {
+	return &Coordinator{
+		Shards: urls,
+		client: &http.Client{Timeout: shardTimeout},
+	}
+}
+
+// get sends a request to a shard and decodes the json answer in v
+func (co *Coordinator) get(shard, endpoint string, params url.Values, v interface{}) error {
+	resp, err := co.client.Get(shard + endpoint + "?" + params.Encode())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %s", shard, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// stats gathers the global statistics of the query terms
+// it returns the shards that couldn't be reached
+func (co *Coordinator) stats(q apiQuery) (*apiStats, []string) {
+	params := url.Values{"corpus": {q.Corpus}, "search": {q.Input}}
+	answers := make([]apiStats, len(co.Shards))
+	errs := co.fanOut(func(i int, shard string) error {
+		return co.get(shard, "/api/stats", params, &answers[i])
+	})
+
+	global := &apiStats{DF: make(map[string]int)}
+	var missing []string
+	for i, stats := range answers {
+		if errs[i] != nil {
+			missing = append(missing, co.Shards[i])
+			continue
+		}
+		global.Documents += stats.Documents
+		for w, df := range stats.DF {
+			global.DF[w] += df
+		}
+	}
+	return global, missing
+}
+
+// fanOut calls f for all shards in parallel and returns their errors
+func (co *Coordinator) fanOut(f func(i int, shard string) error) []error {
+	errs := make([]error, len(co.Shards))
+	// Semaphore channel to wait for all shards
+	sem := make(chan bool)
+	for i, shard := range co.Shards {
+		go func(i int, shard string) {
+			errs[i] = f(i, shard)
+			if errs[i] != nil {
+				log.Printf("shard %s: %s\n", shard, errs[i])
+			}
+			sem <- true
+		}(i, shard)
+	}
+	for range co.Shards {
+		<-sem
+	}
+	return errs
+}
+
+// query sends the query to all shards and merges their answers
+func (co *Coordinator) query(q apiQuery) apiAnswer {
+	var a apiAnswer
+	params := url.Values{
+		"corpus": {q.Corpus},
+		"search": {q.Input},
+		"type":   {q.Type},
+		"weight": {weightParam[q.Weight]},
+		// the page can be made of results from any shard
+		"offset": {"0"},
+		"size":   {strconv.Itoa(q.Offset + q.Size)},
+		"sort":   {q.Sort},
+	}
+	if q.Type != "boolean" {
+		stats, missing := co.stats(q)
+		a.Missing = missing
+		encoded, err := json.Marshal(stats)
+		if err != nil {
+			panic(err)
+		}
+		params.Set("stats", string(encoded))
+	}
+
+	answers := make([]apiAnswer, len(co.Shards))
+	errs := co.fanOut(func(i int, shard string) error {
+		return co.get(shard, "/api/search", params, &answers[i])
+	})
+	for i, answer := range answers {
+		if errs[i] != nil {
+			if !containsString(a.Missing, co.Shards[i]) {
+				a.Missing = append(a.Missing, co.Shards[i])
+			}
+			continue
+		}
+		a.Size += answer.Size
+		a.Results = append(a.Results, answer.Results...)
+	}
+
+	if q.Sort == "date" {
+		sort.SliceStable(a.Results, func(i, j int) bool {
+			if a.Results[i].Date == a.Results[j].Date {
+				return a.Results[i].Id < a.Results[j].Id
+			}
+			return a.Results[i].Date > a.Results[j].Date
+		})
+	} else if q.Type == "boolean" {
+		// boolean results are ordered by id
+		sort.Slice(a.Results, func(i, j int) bool {
+			return a.Results[i].Id < a.Results[j].Id
+		})
+	} else {
+		sort.Slice(a.Results, func(i, j int) bool {
+			if a.Results[i].Score == a.Results[j].Score {
+				return a.Results[i].Id < a.Results[j].Id
+			}
+			return a.Results[i].Score > a.Results[j].Score
+		})
+	}
+	if q.Offset < len(a.Results) {
+		a.Results = a.Results[q.Offset:]
+	} else {
+		a.Results = nil
+	}
+	if len(a.Results) > q.Size {
+		a.Results = a.Results[:q.Size]
+	}
+	return a
+}
+
+// document serves the page of a document from the shard holding it
+// the shards not holding it answer 404 as the document is deleted in their index
+func (co *Coordinator) document(w http.ResponseWriter, r *http.Request) {
+	for _, shard := range co.Shards {
+		resp, err := co.client.Get(shard + r.URL.RequestURI())
+		if err != nil {
+			log.Printf("shard %s: %s\n", shard, err)
+			continue
+		}
+		if resp.StatusCode == http.StatusNotFound {
+			resp.Body.Close()
+			continue
+		}
+		w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+		resp.Body.Close()
+		return
+	}
+	http.NotFound(w, r)
+}
+
+// weightParam is the name of the weight functions as parsed by parseWeight
+var weightParam = [...]string{"raw", "norm", "half"}
+
+func containsString(haystack []string, needle string) bool {
+	for _, hay := range haystack {
+		if hay == needle {
+			return true
+		}
+	}
+	return false
+}
+
+// serveCoordinator serves the search page and api using the shards
+func serveCoordinator(co *Coordinator) {
+	prettyfier := template.FuncMap{
+		"duration": printDuration,
+		"size":     humanize.Bytes,
+	}
+	pattern := path.Join("templates", "*.html")
+	templates := template.Must(template.New("base").Funcs(prettyfier).ParseGlob(pattern))
+
+	hists := make(map[string]metrics.Histogram)
+	for _, name := range corpusNames() {
+		hists[name] = expvar.NewHistogram(name, 50)
+		http.HandleFunc("/"+name+"/", co.document)
+	}
+	lookup := func(corpus string) searcher {
+		if _, ok := hists[corpus]; !ok {
+			return nil
+		}
+		return co
+	}
+	http.HandleFunc("/", searchHandler(templates, lookup, hists))
+	http.HandleFunc("/api/search", apiSearchHandler(lookup))
+
+	http.HandleFunc("/favicon.ico", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, "favicon.ico")
+	})
+
+	log.Printf("riw coordinator for %d shards starting to serve traffic\n", len(co.Shards))
+	log.Fatal(http.ListenAndServe(addr, nil))
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// startShards serves each half of the test corpus as a shard, it returns their urls
+func startShards(t *testing.T) []string {
+	useShards(t, 2, "range")
+	var urls []string
+	for shardIndex = 0; shardIndex < shardCount; shardIndex++ {
+		search := ParseCACM(strings.NewReader(testCACM+testCACMAddition), map[string]bool{})
+		keepShard(search)
+		searches := map[string]*Search{search.Corpus: search}
+		mux := http.NewServeMux()
+		mux.HandleFunc("/api/stats", apiStatsHandler(searches))
+		mux.HandleFunc("/cacm/", docPageHandler(func(w http.ResponseWriter, name string, data interface{}) {
+			fmt.Fprint(w, name)
+		}, search))
+		mux.HandleFunc("/api/search", apiSearchHandler(func(corpus string) searcher {
+			if s, ok := searches[corpus]; ok {
+				return s
+			}
+			return nil
+		}))
+		server := httptest.NewServer(mux)
+		t.Cleanup(server.Close)
+		urls = append(urls, server.URL)
+	}
+	return urls
+}
+
+func TestCoordinator(t *testing.T) {
+	useTempIndexDir(t)
+	urls := startShards(t)
+	whole := ParseCACM(strings.NewReader(testCACM+testCACMAddition), map[string]bool{})
+	q := apiQuery{Corpus: "cacm", Input: "compiler tapes", Weight: raw, Size: 10}
+
+	// the statistics of the shards add up to the ones of the whole corpus
+	co := NewCoordinator(urls)
+	stats, missing := co.stats(q)
+	want := whole.stats(q.Input)
+	if len(missing) != 0 || stats.Documents != want.Documents || len(stats.DF) != len(want.DF) {
+		t.Fatalf("Global stats %+v instead of %+v", stats, want)
+	}
+	for w, df := range want.DF {
+		if stats.DF[w] != df {
+			t.Errorf("df of %s is %d instead of %d", w, stats.DF[w], df)
+		}
+	}
+
+	// so the merged results are scored as in the whole corpus
+	local := whole.query(q)
+	a := co.query(q)
+	if a.Size != local.Size || len(a.Results) != len(local.Results) || len(a.Missing) != 0 {
+		t.Fatalf("Coordinator answered %+v instead of %+v", a, local)
+	}
+	for i, result := range a.Results {
+		if result.Id != local.Results[i].Id || math.Abs(result.Score-local.Results[i].Score) > 1e-9 {
+			t.Errorf("Result %d is %d scored %f instead of %d scored %f", i,
+				result.Id, result.Score, local.Results[i].Id, local.Results[i].Score)
+		}
+	}
+
+	// a shard down is reported, the results of the others are still returned
+	down := "http://127.0.0.1:1"
+	a = NewCoordinator(append(urls, down)).query(q)
+	if len(a.Missing) != 1 || a.Missing[0] != down || len(a.Results) != len(local.Results) {
+		t.Errorf("Answer with a shard down: %+v", a)
+	}
+	a = NewCoordinator(urls[:1]).query(apiQuery{Corpus: "cacm", Input: "compiler", Type: "boolean", Size: 10})
+	if len(a.Results) != 1 || a.Results[0].Id != 0 {
+		t.Errorf("Boolean answer of the first shard: %+v", a.Results)
+	}
+}
+
+func TestCoordinatorDocument(t *testing.T) {
+	useTempIndexDir(t)
+	co := NewCoordinator(startShards(t))
+	// the pages of documents of both shards are served, through the shard holding them
+	for _, external := range []string{"1", "4"} {
+		w := httptest.NewRecorder()
+		co.document(w, httptest.NewRequest("GET", "/cacm/"+external, nil))
+		if body, _ := ioutil.ReadAll(w.Body); w.Code != http.StatusOK || string(body) != "cacm" {
+			t.Errorf("Page of %s answered %d %q", external, w.Code, body)
+		}
+	}
+	w := httptest.NewRecorder()
+	co.document(w, httptest.NewRequest("GET", "/cacm/99", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Page of a missing document answered %d", w.Code)
+	}
+}
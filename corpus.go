@@ -0,0 +1,225 @@
+// Corpus.go defines the corpora riw can index and serve
+// a corpus knows where its documents are, how to scan them and how to show them
+// kinds of corpora (cacm, cs276...) are registered with registerCorpus
+// the -corpora flag lists the corpora to build and serve, as name[=kind[:source]]
+//
+//	rechercheInfoWeb -corpora cacm,cs276,extra=cacm:data/extra.all
+//
+// the kind and source of a corpus are saved with its index so eval and inspect can reload it
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Corpus is a collection of documents of one kind
+type Corpus interface {
+	// Name is the name of the corpus, its index files and pages are named after it
+	Name() string
+	// Kind is the name the kind of corpus is registered with
+	Kind() string
+	// Source is the path of the documents
+	Source() string
+	// Scanner returns a scanner indexing the documents at source in trie
+	// source is Source() or, for documents added to the index, another path
+	Scanner(source string, cw map[string]bool, trie *Root) (Scanner, error)
+	// Text returns the text of a stored document shown in snippets
+	Text(stored []byte) string
+	// Analyze returns the term searched for a word of a query, as scanners index it
+	Analyze(word string) string
+	// Page returns the template and the data of the page of a document
+	Page(s *Search, id int) (string, interface{}, error)
+	// Perf returns the perf of the corpus before indexing, i.e its initial size
+	Perf() Perf
+}
+
+// originalLinker is implemented by corpora whose results link to the original url of a document
+// instead of its page, e.g web archives
+type originalLinker interface {
+	// OriginalUrl returns the url of a document, or an empty string if it has none
+	OriginalUrl(s *Search, id int) string
+}
+
+// testCollection is implemented by corpora coming with topics and relevance judgments
+// the precision/recall pages evaluate the first one built or loaded
+type testCollection interface {
+	// Judgments returns the files of the topics and qrels of the corpus, empty when it has none
+	Judgments() (topics, qrels string)
+}
+
+// newCorpus creates a corpus of a kind from its name and source, the default one when empty
+// it fails if the source, or its options, aren't valid for the kind
+type newCorpus func(name, source string) (Corpus, error)
+
+// corpusKinds are the registered kinds of corpora
+var corpusKinds = make(map[string]newCorpus)
+
+// reservedNames are the first segments of the paths of the server pages, corpora can't be named after them
+// as their documents are served under /name/
+var reservedNames = []string{"api", "admin", "graphs", "stat", "perf", "qrels", "precall", "precall.tsv",
+	"archi", "archi_indexing.svg", "percentile", "favicon.ico", "debug"}
+
+// defaultCorpora is the list of corpora used when -corpora isn't set
+const defaultCorpora = "cacm,cs276"
+
+// corporaList is the value of the -corpora flag, corpora the corpora parsed from it
+var corporaList string
+var corpora []Corpus
+
+// registerCorpus registers a kind of corpus
+func registerCorpus(kind string, new newCorpus) {
+	if _, ok := corpusKinds[kind]; ok {
+		panic("corpus kind registered twice: " + kind)
+	}
+	corpusKinds[kind] = new
+}
+
+// corpusKindNames returns the names of the registered kinds, sorted
+func corpusKindNames() []string {
+	var names []string
+	for kind := range corpusKinds {
+		names = append(names, kind)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseCorpora sets the corpora from a list of name[=kind[:source]]
+// the kind defaults to the name, the source to the default source of the kind
+func parseCorpora(list string) error {
+	var parsed []Corpus
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(list, ",") {
+		name, kind, source := entry, entry, ""
+		if i := strings.Index(entry, "="); i >= 0 {
+			name, kind = entry[:i], entry[i+1:]
+			if j := strings.Index(kind, ":"); j >= 0 {
+				kind, source = kind[:j], kind[j+1:]
+			}
+		}
+		if name == "" || strings.ContainsAny(name, "/:.") {
+			return fmt.Errorf("invalid corpus name %q", name)
+		}
+		if seen[name] {
+			return fmt.Errorf("corpus %s listed twice", name)
+		}
+		seen[name] = true
+		c, err := makeCorpus(name, kind, source)
+		if err != nil {
+			return err
+		}
+		parsed = append(parsed, c)
+	}
+	corpora = parsed
+	return nil
+}
+
+// makeCorpus creates a corpus of a registered kind
+func makeCorpus(name, kind, source string) (Corpus, error) {
+	if containsString(reservedNames, name) {
+		return nil, fmt.Errorf("corpus name %s is reserved for the pages of riw", name)
+	}
+	new, ok := corpusKinds[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown corpus kind %s, expected one of %s",
+			kind, strings.Join(corpusKindNames(), ", "))
+	}
+	return new(name, source)
+}
+
+// configuredCorpora returns the corpora to build and serve
+func configuredCorpora() []Corpus {
+	if corpora == nil {
+		if err := parseCorpora(defaultCorpora); err != nil {
+			panic(err)
+		}
+	}
+	return corpora
+}
+
+// corpusNames returns the names of the configured corpora
+func corpusNames() []string {
+	var names []string
+	for _, c := range configuredCorpora() {
+		names = append(names, c.Name())
+	}
+	return names
+}
+
+// sourceSize returns the size of the files at path, a file or a folder
+func sourceSize(path string) uint64 {
+	var size uint64
+	filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += uint64(info.Size())
+		}
+		return nil
+	})
+	return size
+}
+
+// docPageHandler serves the pages of the documents of a search, under /name/external id
+// the external id is escaped as a single path segment, so slashes are read from the escaped path
+func docPageHandler(render func(w http.ResponseWriter, name string, data interface{}), s *Search) http.HandlerFunc {
+	prefix := "/" + s.Corpus + "/"
+	return func(w http.ResponseWriter, r *http.Request) {
+		external, err := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), prefix))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		id, ok := s.docId(external)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		name, data, err := s.corpus.Page(s, id)
+		if err != nil {
+			log.Println(err)
+			http.NotFound(w, r)
+			return
+		}
+		render(w, name, data)
+	}
+}
+
+// url returns the url of a document, its page or its original url, see originalLinker
+func (s *Search) url(id int) string {
+	if s.corpus == nil {
+		return ""
+	}
+	if l, ok := s.corpus.(originalLinker); ok {
+		if u := l.OriginalUrl(s, id); u != "" {
+			return u
+		}
+	}
+	return s.pageUrl(id)
+}
+
+// pageUrl returns the url of the page of a document, named after its external id
+func (s *Search) pageUrl(id int) string {
+	return "/" + s.Corpus + "/" + url.PathEscape(s.externalId(id))
+}
+
+// analyze returns the term searched for a word of a query
+func (s *Search) analyze(w string) string {
+	if s.corpus == nil {
+		return stem(w)
+	}
+	return s.corpus.Analyze(w)
+}
+
+// text returns the text of a stored document shown in snippets
+func (s *Search) text(stored []byte) string {
+	if s.corpus == nil {
+		return string(stored)
+	}
+	return s.corpus.Text(stored)
+}
@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestCorpusCapabilities(t *testing.T) {
+	for _, name := range []string{"api", "admin", "graphs", "precall"} {
+		if _, err := makeCorpus(name, "cacm", ""); err == nil {
+			t.Errorf("Corpus named %s accepted", name)
+		}
+	}
+	if err := parseCorpora("cacm,stat=cs276"); err == nil {
+		t.Error("Corpus named stat accepted")
+	}
+	// only the cacm file has topics and judgments
+	cacm, _ := makeCorpus("cacm", "cacm", "")
+	extra, _ := makeCorpus("extra", "cacm", "data/extra.all")
+	cs276, _ := makeCorpus("cs276", "cs276", "")
+	if topics, qrels := cacm.(testCollection).Judgments(); topics != topicsFile || qrels != qrelsFile {
+		t.Errorf("cacm judged by %s and %s", topics, qrels)
+	}
+	if topics, _ := extra.(testCollection).Judgments(); topics != "" {
+		t.Errorf("extra judged by %s", topics)
+	}
+	if _, ok := cs276.(testCollection); ok {
+		t.Error("cs276 has judgments")
+	}
+}
@@ -3,14 +3,17 @@
 // A first goroutine list all files available and send filename though a channel
 // multiples worker (goroutineNumber) read this chan and process documents when available
 // Processed documents are indexed concurrently and sent through a chan for metadata (titles...)
+// their ids follow the order of the files, not the one workers finish in, so builds are reproducible
 // This chan also serve to see when processing is finished (by closing it)
 package main
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"errors"
 	"io/ioutil"
 	"log"
-	"os"
 	"reflect"
 	"strings"
 	"unsafe"
@@ -37,49 +40,44 @@ func NewCS276Scanner(root string, trie *Root) *CS276Scanner {
 	}
 }
 
-// scan processes string from the toScan channel
-// it sends parsed document to the channel
-func (s *CS276Scanner) scan(c chan metadata, sem chan bool) {
-	doc := newDocument()
-	for filename := range s.toScan {
-		doc.Title = filename
-		// words of the title are added too
-		words := strings.Split(filename, "_")
-		for _, w := range words[1:] {
-			doc.addToken(w)
-			doc.addWord(w)
-		}
+// index reads a file in the document
+func (s *CS276Scanner) index(doc *Document, filename string) error {
+	doc.Title = filename
+	doc.External = filename
+	// words of the title are added too
+	words := strings.Split(filename, "_")
+	for _, w := range words[1:] {
+		doc.addToken(w)
+		doc.addWord(w)
+	}
 
-		file, err := os.Open(s.root + "/" + filename)
-		defer file.Close()
-		if err != nil {
-			log.Println(err)
-			break
-		}
-		scanner := bufio.NewScanner(file)
-		scanner.Split(scanWords)
-		for scanner.Scan() {
-			w := BytesToString(scanner.Bytes())
-			// all lexeme are compted as "seen"
-			doc.addToken(w)
-			doc.addWord(w)
-		}
-		s.trie.addDoc(doc)
-		c <- metadataFromDoc(doc)
-		doc.reset()
-		file.Close()
+	// the file is read at once as its text is kept in the document store
+	content, err := ioutil.ReadFile(s.root + "/" + filename)
+	if err != nil {
+		return err
 	}
-	sem <- true
+	doc.Text = content
+	doc.Read = len(content)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Split(scanWords)
+	for scanner.Scan() {
+		w := BytesToString(scanner.Bytes())
+		// all lexeme are compted as "seen"
+		doc.addToken(w)
+		doc.addWord(w)
+	}
+	return nil
 }
 
 // Scan will send scanned doc to the channel using multiple goroutine to parse them
-func (s *CS276Scanner) Scan(c chan metadata) {
+func (s *CS276Scanner) Scan(ctx context.Context, c chan metadata) {
 	dirs, err := ioutil.ReadDir(s.root)
 	if err != nil {
 		panic(err)
 	}
 	// goroutine that will add all file to parse by reading dir in order
 	go func() {
+		defer close(s.toScan)
 		for _, dir := range dirs {
 			files, err := ioutil.ReadDir(s.root + "/" + dir.Name())
 			if err != nil {
@@ -87,16 +85,66 @@ func (s *CS276Scanner) Scan(c chan metadata) {
 				continue
 			}
 			for _, file := range files {
+				if ctx.Err() != nil {
+					return
+				}
 				s.toScan <- (dir.Name() + "/" + file.Name())
 			}
 		}
-		close(s.toScan)
+	}()
+	scanConcurrently(ctx, c, s.toScan, s.trie, s.index)
+}
+
+// errNotDocument is returned by the index function of scanConcurrently for items which aren't documents
+// they are skipped without being logged
+var errNotDocument = errors.New("not a document")
+
+// scanConcurrently indexes the items sent on toScan, file names, document texts or records, with goroutineNumber workers
+// index reads an item in a document, which is then added to the trie and its metadata sent
+// items that can't be read are logged and skipped, c is closed once all items are indexed
+// ids follow the order of toScan, see turn
+// once ctx is cancelled the items left are skipped, senders on toScan should then stop and close it
+func scanConcurrently[T any](ctx context.Context, c chan metadata, toScan chan T, trie *Root, index func(doc *Document, item T) error) {
+	type turnItem struct {
+		item T
+		turn turn
+	}
+	items := make(chan turnItem, 100)
+	go func() {
+		turns := newTurns()
+		for item := range toScan {
+			if ctx.Err() == nil {
+				items <- turnItem{item, turns.next()}
+			}
+		}
+		close(items)
 	}()
 	// Semaphore to wait for all routine to be done
 	sem := make(chan bool, 2)
 	// goroutine parsing files
 	for i := 0; i < goroutineNumber; i++ {
-		go s.scan(c, sem)
+		go func() {
+			target := trie.worker()
+			doc := newDocument()
+			for it := range items {
+				if ctx.Err() != nil {
+					it.turn.skip()
+					continue
+				}
+				if err := index(doc, it.item); err != nil {
+					if err != errNotDocument {
+						log.Println(err)
+					}
+					it.turn.skip()
+					doc.reset()
+					continue
+				}
+				target.addDocInTurn(doc, it.turn)
+				c <- metadataFromDoc(doc)
+				doc.reset()
+			}
+			sem <- true
+		}()
 	}
 	// goroutine to close the chan when all goroutines are done
 	go func() {
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestOrderedIds(t *testing.T) {
+	toScan := make(chan string)
+	go func() {
+		for i := 0; i < 3*goroutineNumber; i++ {
+			toScan <- strconv.Itoa(i)
+		}
+		close(toScan)
+	}()
+	c := make(chan metadata)
+	scanConcurrently(context.Background(), c, toScan, NewTrie(), func(doc *Document, item string) error {
+		n, _ := strconv.Atoi(item)
+		if n%10 == 3 {
+			return fmt.Errorf("item %d can't be read", n)
+		}
+		// the first items take longer so workers finish in reverse order
+		time.Sleep(time.Duration(3*goroutineNumber-n) * 100 * time.Microsecond)
+		doc.External = item
+		doc.addWord("compiler")
+		return nil
+	})
+	for m := range c {
+		n, _ := strconv.Atoi(m.external)
+		// ids skip the items which couldn't be read
+		if m.id != n-(n+6)/10 {
+			t.Errorf("Item %s got id %d", m.external, m.id)
+		}
+	}
+}
+
+// writeTestCS276 writes 3 folders of 40 pages in the layout of cs276, it returns the folder
+func writeTestCS276(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "cs276")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for d := 0; d < 3; d++ {
+		os.Mkdir(filepath.Join(dir, strconv.Itoa(d)), 0755)
+		for f := 0; f < 40; f++ {
+			text := bytes.Repeat([]byte(fmt.Sprintf("word%d compiler%d ", f%7, d)), 1+(f*37)%200)
+			ioutil.WriteFile(filepath.Join(dir, strconv.Itoa(d), fmt.Sprintf("www.x.edu_%02d", f)), text, 0644)
+		}
+	}
+	return dir
+}
+
+func TestReproducibleCS276(t *testing.T) {
+	useTempIndexDir(t)
+	dir := writeTestCS276(t)
+	defer os.RemoveAll(dir)
+	corpus, _ := makeCorpus("testorder", "cs276", dir)
+	var indexes [2][]byte
+	for i := range indexes {
+		search := ParseCorpus(context.Background(), corpus, map[string]bool{})
+		seg := search.Segments[0]
+		for id, external := range seg.Externals {
+			if want := fmt.Sprintf("%d/www.x.edu_%02d", id/40, id%40); external != want {
+				t.Fatalf("Document %d is %s instead of %s", id, external, want)
+			}
+		}
+		seg.Index.Serialize("testorder")
+		indexes[i], _ = ioutil.ReadFile(indexFile("testorder.index"))
+	}
+	if len(indexes[0]) == 0 || !bytes.Equal(indexes[0], indexes[1]) {
+		t.Error("Indexing twice gave different index files")
+	}
+}
@@ -1,6 +1,10 @@
 package main
 
-import "github.com/surgebase/porter2"
+import (
+	"strings"
+
+	"github.com/surgebase/porter2"
+)
 
 // weight serves to identify the different weight that can be used
 type weight int
@@ -51,6 +55,21 @@ type Document struct {
 	Tokens int
 	// Id is the id of the document (unique in the search)
 	Id int
+	// External is the id of the document in its corpus, e.g the .I of cacm or the DOCNO of trec
+	// it doesn't depend on the order documents are indexed in, see Search.docId
+	External string
+	// Cites are the external ids of the documents it cites
+	Cites []string
+	// Date is the publication date, see documentDate
+	Date int
+	// Text is the text of the document as read, it must not be reused once sent
+	Text []byte
+	// Url is the url of a web page, Links the urls it links to
+	// links are resolved to citations once all documents are read
+	Url   string
+	Links []string
+	// Read is the number of bytes of the source read for the document, to report progress
+	Read int
 }
 
 func newDocument() *Document {
@@ -59,9 +78,48 @@ func newDocument() *Document {
 
 // addWord add a word to the model, for now freqs are only stored as count actually
 func (d *Document) addWord(w string) {
+	d.addTerm(stem(w))
+}
+
+// addText adds the words of a text to the document, filtered as CACMScanner does
+// all words are tokens, common words and words shorter than 3 letters aren't indexed
+// the words are also added as terms of a field when prefix isn't empty
+func (d *Document) addText(text, prefix string, cw map[string]bool) {
+	for _, lit := range strings.FieldsFunc(text, isNotToken) {
+		d.addToken(lit)
+		if len(lit) < 3 || cw[lit] {
+			continue
+		}
+		d.addWord(lit)
+		if prefix != "" {
+			d.addTerm(prefix + stem(lit))
+		}
+	}
+}
+
+// addFieldTerms adds the words of a text as terms of a field only, for text already added
+func (d *Document) addFieldTerms(text, prefix string, cw map[string]bool) {
+	for _, lit := range strings.FieldsFunc(text, isNotToken) {
+		if len(lit) >= 3 && !cw[lit] {
+			d.addTerm(prefix + stem(lit))
+		}
+	}
+}
+
+func isNotToken(r rune) bool {
+	return !tokenMember(r)
+}
+
+// stem returns the stem of a word, short words are kept as is
+func stem(w string) string {
 	if len(w) > 3 {
-		w = porter2.Stem(w)
+		return porter2.Stem(w)
 	}
+	return w
+}
+
+// addTerm adds a term to the model as is, without stemming
+func (d *Document) addTerm(w string) {
 	i := getWordIndex(d.Words, w)
 	if i < len(d.Words) && d.Words[i] == w {
 		d.Count[i]++
@@ -73,7 +131,7 @@ func (d *Document) addWord(w string) {
 		d.Words = append(d.Words, "")
 		copy(d.Count[i+1:], d.Count[i:])
 		copy(d.Words[i+1:], d.Words[i:])
-		d.Count[i]++
+		d.Count[i] = 1
 		d.Words[i] = w
 	}
 	d.Size += 1
@@ -89,6 +147,13 @@ func (d *Document) reset() {
 	d.Words = d.Words[:0]
 	d.Size = 0
 	d.Tokens = 0
+	d.Cites = nil
+	d.Date = 0
+	d.Text = nil
+	d.Url = ""
+	d.Links = nil
+	d.External = ""
+	d.Read = 0
 }
 
 func getWordIndex(words []string, w string) int {
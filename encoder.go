@@ -22,10 +22,11 @@ const (
 // Serialize save to file the trie
 func (r *Root) Serialize(name string) {
 	now := time.Now()
-	index, err := os.Create("indexes/" + name + ".index")
+	index, err := createIndexFile(name + ".index")
 	if err != nil {
 		panic(err)
 	}
+	defer index.Close()
 	buffered := snappy.NewBufferedWriter(index)
 
 	// 9 is the size of a uint64 + 1, see encodeUint for details
@@ -33,11 +34,7 @@ func (r *Root) Serialize(name string) {
 	encodeUInt(buffered, uint(r.count), buf)
 	r.Node.Encode(buffered, buf)
 	buffered.Flush()
-
-	err = index.Close()
-	if err != nil {
-		panic(err.Error())
-	}
+	index.commit()
 	log.Printf("%s index serialization took %s", name, time.Since(now))
 }
 
@@ -45,7 +42,7 @@ func (r *Root) Serialize(name string) {
 func UnserializeTrie(name string) *Root {
 	now := time.Now()
 	r := &Root{}
-	index, err := os.Open("indexes/" + name + ".index")
+	index, err := os.Open(indexFile(name + ".index"))
 	if err != nil {
 		panic(err)
 	}
@@ -75,24 +72,28 @@ func UnserializeTrie(name string) *Root {
 // [len(sons] len(str) str
 // [len(sons)] *Node
 func (n *Node) Encode(encoder io.Writer, buf []byte) {
-	encodeUInt(encoder, uint(len(n.Refs)), buf)
-	if len(n.Refs) > 0 {
-		for _, ref := range n.Refs {
+	encodeRefs(encoder, n.Refs, buf)
+	encodeStringSlice(encoder, n.Radix, buf)
+	for _, sons := range n.Sons {
+		sons.Encode(encoder, buf)
+	}
+}
+
+// encodeRefs writes a list of refs, their weights then their delta encoded ids
+func encodeRefs(encoder io.Writer, refs []Ref, buf []byte) {
+	encodeUInt(encoder, uint(len(refs)), buf)
+	if len(refs) > 0 {
+		for _, ref := range refs {
 			for i := 0; i < total; i++ {
 				encodeFloat(encoder, ref.Weights[i], buf)
 			}
 		}
-		encodeUInt(encoder, uint(n.Refs[0].Id), buf)
-		for i := 1; i < len(n.Refs); i++ {
+		encodeUInt(encoder, uint(refs[0].Id), buf)
+		for i := 1; i < len(refs); i++ {
 			// delta encoding
-			encodeUInt(encoder, uint(n.Refs[i].Id-n.Refs[i-1].Id), buf)
+			encodeUInt(encoder, uint(refs[i].Id-refs[i-1].Id), buf)
 		}
 	}
-
-	encodeStringSlice(encoder, n.Radix, buf)
-	for _, sons := range n.Sons {
-		sons.Encode(encoder, buf)
-	}
 }
 
 // Decode decodes from an io.reader
@@ -105,26 +106,31 @@ func (n *Node) Encode(encoder io.Writer, buf []byte) {
 // [len(sons] len(str) str
 // [len(sons)] *Node
 func (n *Node) Decode(decoder io.Reader, buf []byte) {
+	n.Refs = decodeRefs(decoder, buf)
+	n.Radix = decodeStringSlice(decoder, buf)
+	n.Sons = make([]*Node, len(n.Radix))
+	for i := 0; i < len(n.Radix); i++ {
+		n.Sons[i] = &Node{}
+		n.Sons[i].Decode(decoder, buf)
+	}
+}
+
+// decodeRefs reads a list of refs written by encodeRefs
+func decodeRefs(decoder io.Reader, buf []byte) []Ref {
 	length := int(decodeUInt(decoder, buf))
-	n.Refs = make([]Ref, length)
+	refs := make([]Ref, length)
 	for i := 0; i < length; i++ {
 		for j := 0; j < total; j++ {
-			n.Refs[i].Weights[j] = decodeFloat(decoder, buf)
+			refs[i].Weights[j] = decodeFloat(decoder, buf)
 		}
 	}
 	if length > 0 {
-		n.Refs[0].Id = int(decodeUInt(decoder, buf))
+		refs[0].Id = int(decodeUInt(decoder, buf))
 		for i := 1; i < length; i++ {
-			n.Refs[i].Id = int(decodeUInt(decoder, buf)) + n.Refs[i-1].Id
+			refs[i].Id = int(decodeUInt(decoder, buf)) + refs[i-1].Id
 		}
 	}
-
-	n.Radix = decodeStringSlice(decoder, buf)
-	n.Sons = make([]*Node, len(n.Radix))
-	for i := 0; i < len(n.Radix); i++ {
-		n.Sons[i] = &Node{}
-		n.Sons[i].Decode(decoder, buf)
-	}
+	return refs
 }
 
 // encodeUInt writes an uint to w
@@ -55,6 +55,7 @@ func TestEncodeStringSlice(t *testing.T) {
 }
 
 func TestEncodeTrie(t *testing.T) {
+	useTempIndexDir(t)
 	trie := NewTrie()
 	for i, w := range testWords {
 		var wf weights
@@ -62,7 +63,6 @@ func TestEncodeTrie(t *testing.T) {
 		trie.add(w, i, wf)
 	}
 	trie.Serialize("test")
-	//defer os.Remove(path.Join("indexes", "test.index"))
 	unserialized := UnserializeTrie("test")
 	for _, w := range testWords {
 		resp := unserialized.get(w)
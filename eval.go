@@ -0,0 +1,192 @@
+// Eval.go implements the eval subcommand, it compares retrieval configurations on a test collection
+//
+//	rechercheInfoWeb eval -corpus cacm -configs boolean,raw,half,half+pagerank -measure map
+//
+// every topic is run against each configuration, the mean of the measures is printed
+// then each ranked configuration is compared to the first one with significance tests
+// the boolean configuration returns an unranked set, it's only evaluated with the set measures
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// retrieval is a configuration of the search that can be evaluated
+// set is true when its results are unranked, as the ones of boolean queries
+type retrieval struct {
+	Name string
+	run  func(s *Search, topic Topic) []Ref
+	set  bool
+}
+
+// retrievals returns the configurations eval can compare, new models are added here
+func retrievals() []retrieval {
+	configs := []retrieval{
+		{"boolean", func(s *Search, topic Topic) []Ref {
+			return BooleanQuery(s, topic.Boolean)
+		}, true},
+	}
+	for wf := 0; wf < total; wf++ {
+		wf := weight(wf)
+		configs = append(configs, retrieval{Name: weightParam[wf], run: func(s *Search, topic Topic) []Ref {
+			return TopicQuery(s, topic, wf)
+		}})
+	}
+	// the same weights mixed with each static score of the citation graph
+	for wf := 0; wf < total; wf++ {
+		wf := weight(wf)
+		for _, name := range staticScores {
+			name := name
+			configs = append(configs, retrieval{Name: weightParam[wf] + "+" + name, run: func(s *Search, topic Topic) []Ref {
+				refs := TopicQuery(s, topic, wf)
+				s.mixStatic(refs, wf, name, staticMix)
+				sortRefs(refs, wf)
+				return refs
+			}})
+		}
+	}
+	return configs
+}
+
+// evaluation runs the eval subcommand with its arguments
+func evaluation(args []string) {
+	flags := flag.NewFlagSet("eval", flag.ExitOnError)
+	corpus := flags.String("corpus", "cacm", "-corpus name of the index to evaluate")
+	topics := flags.String("topics", "data/CACM/query.text", "-topics file of queries, CACM or TREC topics, with an optional .bool file alongside")
+	qrels := flags.String("qrels", "data/CACM/qrels.text", "-qrels file of relevance judgments, CACM or TREC qrels")
+	names := flags.String("configs", "", "-configs a,b list of configurations to compare, the first is the baseline, all by default")
+	measure := flags.String("measure", "map", "-measure name of the measure used for the significance tests")
+	trials := flags.Int("trials", 10000, "-trials number of permutations of the randomization test")
+	flags.Float64Var(&staticMix, "mix", 0.2, "-mix weight of the static score in the configurations mixing one, as half+pagerank")
+	boost := flags.String("boost", "", "-boost title=1,keyword=1 boosts of the fields in which plain query words are also searched")
+	flags.StringVar(&indexDir, "indexes", "indexes", "-indexes dir to use another folder for the indexes")
+	flags.Parse(args)
+
+	var configs []retrieval
+	if *names == "" {
+		configs = retrievals()
+	} else {
+		for _, name := range strings.Split(*names, ",") {
+			var found bool
+			for _, config := range retrievals() {
+				if config.Name == name {
+					configs = append(configs, config)
+					found = true
+				}
+			}
+			if !found {
+				fmt.Fprintf(os.Stderr, "unknown configuration %s\n", name)
+				os.Exit(1)
+			}
+		}
+	}
+	if *boost != "" {
+		if err := parseBoosts(*boost); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	if _, ok := (Measures{}).value(*measure); !ok {
+		fmt.Fprintf(os.Stderr, "unknown measure %s, expected one of %s\n", *measure, strings.Join(measureNames, ", "))
+		os.Exit(1)
+	}
+
+	search, err := UnserializeSearch(*corpus)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	judgments := ReadQrels(*qrels)
+	ids := search.docIDs()
+	// only topics with relevant documents can be evaluated
+	var evaluated []Topic
+	var judged []map[int]int
+	all := ReadTopics(*topics)
+	addBooleanTopics(all, booleanFile(*topics), search.CW)
+	for _, topic := range all {
+		j := resolveJudgments(judgments[topic.Id], ids)
+		if relevantCount(j) > 0 {
+			evaluated = append(evaluated, topic)
+			judged = append(judged, j)
+		}
+	}
+	fmt.Printf("%s: %d topics evaluated\n\n", search.Corpus, len(evaluated))
+
+	var ranked, sets []retrieval
+	for _, config := range configs {
+		if config.set {
+			sets = append(sets, config)
+		} else {
+			ranked = append(ranked, config)
+		}
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	if len(sets) > 0 {
+		fmt.Fprintln(tw, "config\tretrieved\tprecision\trecall\tF1\t")
+		for _, config := range sets {
+			all := make([]SetMeasures, len(evaluated))
+			for i, topic := range evaluated {
+				all[i] = evaluateSet(refIds(config.run(search, topic)), judged[i])
+			}
+			mean := meanSetMeasures(all)
+			fmt.Fprintf(tw, "%s\t%.1f\t%.4f\t%.4f\t%.4f\t\n", config.Name, mean.Retrieved, mean.Precision, mean.Recall, mean.F1)
+		}
+		tw.Flush()
+		if len(ranked) > 0 {
+			fmt.Println()
+		}
+	}
+	if len(ranked) == 0 {
+		return
+	}
+
+	measures := make([][]Measures, len(ranked))
+	fmt.Fprintf(tw, "config\t%s\t\n", strings.Join(measureNames, "\t"))
+	for c, config := range ranked {
+		measures[c] = make([]Measures, len(evaluated))
+		for i, topic := range evaluated {
+			measures[c][i] = evaluate(refIds(config.run(search, topic)), judged[i])
+		}
+		mean := meanMeasures(measures[c])
+		fmt.Fprintf(tw, "%s", config.Name)
+		for _, name := range measureNames {
+			value, _ := mean.value(name)
+			fmt.Fprintf(tw, "\t%.4f", value)
+		}
+		fmt.Fprintln(tw, "\t")
+	}
+	tw.Flush()
+
+	if len(ranked) < 2 {
+		return
+	}
+	fmt.Printf("\nsignificance of %s against %s\n\n", *measure, ranked[0].Name)
+	baseline := perQuery(measures[0], *measure)
+	fmt.Fprintln(tw, "config\tdifference\tt-test p\trandomization p\t")
+	for c := 1; c < len(ranked); c++ {
+		values := perQuery(measures[c], *measure)
+		var diff float64
+		for i := range values {
+			diff += values[i] - baseline[i]
+		}
+		if len(values) > 0 {
+			diff /= float64(len(values))
+		}
+		fmt.Fprintf(tw, "%s\t%+.4f\t%.4f\t%.4f\t\n", ranked[c].Name, diff,
+			pairedTTest(values, baseline), randomizationTest(values, baseline, *trials))
+	}
+	tw.Flush()
+}
+
+// perQuery returns the values of a measure for each query
+func perQuery(measures []Measures, name string) []float64 {
+	values := make([]float64, len(measures))
+	for i, m := range measures {
+		values[i], _ = m.value(name)
+	}
+	return values
+}
@@ -0,0 +1,277 @@
+// Fields.go implements the terms of structured fields of documents
+// field terms are stored in the same trie as the words, with the name of their field as prefix
+// e.g the author "Perlis, A. J." of a cacm document is indexed as "author:perlis"
+// the prefix can't clash with words as they never contain ':'
+//
+// the words of the title, abstract and keywords are indexed both as plain words and as field terms
+// so queries can either search the whole document or a single field, "title:compiler author:knuth"
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// prefixes of the field terms
+const (
+	titlePrefix    = "title:"
+	abstractPrefix = "abstract:"
+	keywordPrefix  = "keyword:"
+	authorPrefix   = "author:"
+	yearPrefix     = "year:"
+	headingPrefix  = "heading:"
+)
+
+// fieldPrefixes are the prefixes that can be used in queries
+var fieldPrefixes = []string{titlePrefix, abstractPrefix, keywordPrefix, authorPrefix, yearPrefix, headingPrefix}
+
+// fieldBoost is the boost of the score of a field
+type fieldBoost struct {
+	Prefix string
+	Boost  float64
+}
+
+// fieldBoosts are the fields in which the plain words of vector queries are also searched
+// the score of a word in one of those fields is multiplied by its boost and added to the score of the word
+// so with a boost of 0.5 a word of the title counts one and a half times
+var fieldBoosts = []fieldBoost{{titlePrefix, 0.5}, {keywordPrefix, 0.5}}
+
+// termBoost returns the boost of a term, 1 for plain words and fields without boost
+func termBoost(term string) float64 {
+	for _, fb := range fieldBoosts {
+		if strings.HasPrefix(term, fb.Prefix) {
+			return fb.Boost
+		}
+	}
+	return 1
+}
+
+// parseBoosts sets the field boosts from a "field=boost,field=boost" list, as given on the command line
+// a boost of 0 disables the field
+func parseBoosts(list string) error {
+	var boosts []fieldBoost
+	for _, item := range strings.Split(list, ",") {
+		if item == "" {
+			continue
+		}
+		var fb fieldBoost
+		i := strings.Index(item, "=")
+		if i < 0 {
+			return fmt.Errorf("boost %q isn't written field=boost", item)
+		}
+		fb.Prefix = item[:i] + ":"
+		if !isFieldPrefix(fb.Prefix) {
+			return fmt.Errorf("unknown field %q", item[:i])
+		}
+		// query words aren't names nor years, they are only searched in those fields with field:value
+		if fb.Prefix == authorPrefix || fb.Prefix == yearPrefix {
+			return fmt.Errorf("field %q can't be boosted", item[:i])
+		}
+		var err error
+		if fb.Boost, err = strconv.ParseFloat(item[i+1:], 64); err != nil {
+			return fmt.Errorf("boost of %s: %v", item[:i], err)
+		}
+		if fb.Boost > 0 {
+			boosts = append(boosts, fb)
+		}
+	}
+	fieldBoosts = boosts
+	return nil
+}
+
+// isFieldPrefix returns wether prefix is the prefix of a field
+func isFieldPrefix(prefix string) bool {
+	for _, p := range fieldPrefixes {
+		if p == prefix {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldTerm returns the term of a "field:value" query word
+// it returns false if the word doesn't start with a known field
+// and an empty term if the value has nothing to search
+func fieldTerm(word string) (string, bool) {
+	i := strings.Index(word, ":")
+	if i < 0 || !isFieldPrefix(word[:i+1]) {
+		return "", false
+	}
+	prefix, value := word[:i+1], word[i+1:]
+	switch prefix {
+	case authorPrefix:
+		return authorTerm(value), true
+	case yearPrefix:
+		return yearTerm(value), true
+	}
+	value = strings.Map(func(r rune) rune {
+		if splitter(r) {
+			return -1
+		}
+		return r
+	}, value)
+	if value == "" {
+		return "", true
+	}
+	return prefix + stem(value), true
+}
+
+// authorTerm returns the term of an author name, written "Surname, Initials" or "Surname"
+// only the surname is kept, lowercased and without spaces or punctuation
+// so "De Millo, R.A." and "DeMillo" are the same author
+// it returns an empty string if there is no surname
+func authorTerm(name string) string {
+	if i := strings.Index(name, ","); i >= 0 {
+		name = name[:i]
+	}
+	name = strings.Map(func(r rune) rune {
+		if !unicode.IsLetter(r) {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, name)
+	if name == "" {
+		return ""
+	}
+	return authorPrefix + name
+}
+
+// yearRange is a range of publication years, written "year:1970..1975" in queries
+// either bound can be omitted, "year:1970.." or "year:..1975"
+// ranges aren't terms, they filter the results of queries on the publication date
+type yearRange struct {
+	From, To int
+}
+
+// parseYearRange returns the range of a "year:from..to" query word, or false if it isn't a range
+func parseYearRange(word string) (yearRange, bool) {
+	if !strings.HasPrefix(word, yearPrefix) {
+		return yearRange{}, false
+	}
+	bounds := strings.Split(word[len(yearPrefix):], "..")
+	if len(bounds) != 2 {
+		return yearRange{}, false
+	}
+	r := yearRange{To: math.MaxInt32}
+	var err error
+	if bounds[0] != "" {
+		if r.From, err = strconv.Atoi(bounds[0]); err != nil {
+			return yearRange{}, false
+		}
+	}
+	if bounds[1] != "" {
+		if r.To, err = strconv.Atoi(bounds[1]); err != nil {
+			return yearRange{}, false
+		}
+	}
+	return r, true
+}
+
+// contains returns wether a date, as stored by documentDate, is in the range
+// documents without a date are never in a range
+func (r yearRange) contains(date int) bool {
+	year := date / 100
+	return date != 0 && year >= r.From && year <= r.To
+}
+
+// queryWords splits a query in words, "field:value" words are kept whole
+// e.g "compiler year:1970..1975" is split in "compiler" and "year:1970..1975"
+func queryWords(input string) []string {
+	var words []string
+	for _, word := range strings.FieldsFunc(input, querySplitter) {
+		// a dot ending a sentence isn't part of the value, unlike the ones of an open range
+		if strings.HasSuffix(word, ".") && !strings.HasSuffix(word, "..") {
+			word = word[:len(word)-1]
+		}
+		if i := strings.Index(word, ":"); i >= 0 && isFieldPrefix(word[:i+1]) {
+			words = append(words, word)
+			continue
+		}
+		words = append(words, strings.FieldsFunc(word, splitter)...)
+	}
+	return words
+}
+
+// yearRanges returns the year ranges of a query and its other words
+func yearRanges(words []string) ([]yearRange, []string) {
+	var ranges []yearRange
+	others := words[:0:0]
+	for _, word := range words {
+		if r, ok := parseYearRange(word); ok {
+			ranges = append(ranges, r)
+		} else {
+			others = append(others, word)
+		}
+	}
+	return ranges, others
+}
+
+// filterDates keeps the refs of documents published in all the ranges, in the same order
+func (s *Search) filterDates(refs []Ref, ranges []yearRange) []Ref {
+	if len(ranges) == 0 {
+		return refs
+	}
+	filtered := make([]Ref, 0, len(refs))
+	for _, ref := range refs {
+		date := s.date(ref.Id)
+		in := true
+		for _, r := range ranges {
+			in = in && r.contains(date)
+		}
+		if in {
+			filtered = append(filtered, ref)
+		}
+	}
+	return filtered
+}
+
+// sortByDate sorts refs from the most recent document, documents without a date come last
+// documents of a same date keep their order, e.g by score
+func (s *Search) sortByDate(refs []Ref) {
+	sort.SliceStable(refs, func(i, j int) bool {
+		return s.date(refs[i].Id) > s.date(refs[j].Id)
+	})
+}
+
+// documentDate returns the date of a publication from its month name and year, as year*100 + month
+// the month is 0 when unknown and the date 0 when the year is
+func documentDate(month string, year int) int {
+	if year == 0 {
+		return 0
+	}
+	for m := time.January; m <= time.December; m++ {
+		if strings.EqualFold(month, m.String()) {
+			return year*100 + int(m)
+		}
+	}
+	return year * 100
+}
+
+// formatDate returns a date stored by documentDate as "December 1958", or an empty string
+func formatDate(date int) string {
+	switch {
+	case date == 0:
+		return ""
+	case date%100 == 0:
+		return strconv.Itoa(date / 100)
+	}
+	return fmt.Sprintf("%s %d", time.Month(date%100), date/100)
+}
+
+// yearTerm returns the term of a year, or an empty string if lit isn't a four digits year
+func yearTerm(lit string) string {
+	if len(lit) != 4 {
+		return ""
+	}
+	for _, r := range lit {
+		if r < '0' || r > '9' {
+			return ""
+		}
+	}
+	return yearPrefix + lit
+}
@@ -0,0 +1,294 @@
+// Graph.go implements the citation graph of a corpus and the static scores computed from it
+//
+// cacm documents list their links in the .X field, as "number 5 number" lines
+// a link is written in the records of both documents, the most recent document being the citing one
+// web pages cite the pages of the corpus they link to
+// the static scores, PageRank and HITS hubs and authorities, are computed once when the index is built
+// and can be mixed into the score of vector queries
+package main
+
+import (
+	"encoding/gob"
+	"math"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	// damping is the probability to follow a link in PageRank
+	damping = 0.85
+	// rankIterations bounds the iterations of PageRank and HITS
+	rankIterations = 100
+	// rankEpsilon stops iterations once scores change less than it
+	rankEpsilon = 1e-10
+)
+
+// staticScores are the names of the static scores
+var staticScores = []string{"pagerank", "authority", "hub"}
+
+// staticScore is the name of the static score mixed into vector queries, none when empty
+// staticMix is its weight, see mixStatic
+var staticScore string
+var staticMix float64
+
+// Graph is the citation graph of a corpus, indexed by document ids
+type Graph struct {
+	// Cites are the documents cited by each document, CitedBy the documents citing it
+	Cites   [][]int
+	CitedBy [][]int
+	// static scores of the documents
+	PageRank  []float64
+	Authority []float64
+	Hub       []float64
+}
+
+// addCitations adds the documents cited by a document, ignoring self citations
+func (g *Graph) addCitations(id int, cited []int) {
+	for _, to := range cited {
+		if to == id || to < 0 {
+			continue
+		}
+		g.grow(id)
+		g.grow(to)
+		if !containsId(g.Cites[id], to) {
+			g.Cites[id] = append(g.Cites[id], to)
+			g.CitedBy[to] = append(g.CitedBy[to], id)
+		}
+	}
+}
+
+// addLinks adds the citations of documents from the keys of the documents they cite
+// keys are the urls of web pages, where a link to a folder also matches its index.html, or external ids
+// ids are the documents by key, links to documents outside of the corpus are ignored
+func (g *Graph) addLinks(ids map[string]int, links map[int][]string) {
+	citing := make([]int, 0, len(links))
+	for id := range links {
+		citing = append(citing, id)
+	}
+	// pages are added in id order so CitedBy doesn't depend of the map order
+	sort.Ints(citing)
+	for _, id := range citing {
+		var cited []int
+		for _, link := range links[id] {
+			to, ok := ids[link]
+			if !ok && strings.HasSuffix(link, "/") {
+				to, ok = ids[link+"index.html"]
+			}
+			if ok {
+				cited = append(cited, to)
+			}
+		}
+		g.addCitations(id, cited)
+	}
+}
+
+// grow extends the graph so it holds the document id
+func (g *Graph) grow(id int) {
+	for len(g.Cites) <= id {
+		g.Cites = append(g.Cites, nil)
+		g.CitedBy = append(g.CitedBy, nil)
+	}
+}
+
+// containsId returns wether id is in ids
+func containsId(ids []int, id int) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+// rank computes the static scores of the n documents of the corpus
+func (g *Graph) rank(n int) {
+	g.grow(n - 1)
+	for id := range g.Cites {
+		sort.Ints(g.Cites[id])
+		sort.Ints(g.CitedBy[id])
+	}
+	g.PageRank = pageRank(g.Cites)
+	g.Hub, g.Authority = hits(g.Cites, g.CitedBy)
+}
+
+// pageRank computes the PageRank of the documents by power iteration
+// the rank of documents citing nothing is spread over all documents
+func pageRank(cites [][]int) []float64 {
+	n := float64(len(cites))
+	rank := make([]float64, len(cites))
+	next := make([]float64, len(cites))
+	for i := range rank {
+		rank[i] = 1 / n
+	}
+	for it := 0; it < rankIterations; it++ {
+		var dangling float64
+		for i, out := range cites {
+			if len(out) == 0 {
+				dangling += rank[i]
+			}
+		}
+		base := (1-damping)/n + damping*dangling/n
+		for i := range next {
+			next[i] = base
+		}
+		for i, out := range cites {
+			for _, j := range out {
+				next[j] += damping * rank[i] / float64(len(out))
+			}
+		}
+		var delta float64
+		for i := range rank {
+			delta += math.Abs(next[i] - rank[i])
+		}
+		rank, next = next, rank
+		if delta < rankEpsilon {
+			break
+		}
+	}
+	return rank
+}
+
+// hits computes the hub and authority scores of Kleinberg's HITS
+// good hubs cite good authorities, both are normalized to a unit norm
+func hits(cites, citedBy [][]int) ([]float64, []float64) {
+	hub := make([]float64, len(cites))
+	authority := make([]float64, len(cites))
+	for i := range hub {
+		hub[i] = 1
+	}
+	for it := 0; it < rankIterations; it++ {
+		for i, in := range citedBy {
+			authority[i] = 0
+			for _, j := range in {
+				authority[i] += hub[j]
+			}
+		}
+		normalize(authority)
+		var delta float64
+		for i, out := range cites {
+			var h float64
+			for _, j := range out {
+				h += authority[j]
+			}
+			delta += math.Abs(h - hub[i])
+			hub[i] = h
+		}
+		delta += normalize(hub)
+		if delta < rankEpsilon {
+			break
+		}
+	}
+	return hub, authority
+}
+
+// normalize scales scores to a unit euclidean norm, it returns the norm before scaling
+func normalize(scores []float64) float64 {
+	var norm float64
+	for _, s := range scores {
+		norm += s * s
+	}
+	norm = math.Sqrt(norm)
+	if norm == 0 {
+		return 0
+	}
+	for i := range scores {
+		scores[i] /= norm
+	}
+	return norm
+}
+
+// scores returns a static score from its name, nil if it doesn't exist
+func (g *Graph) scores(name string) []float64 {
+	switch name {
+	case "pagerank":
+		return g.PageRank
+	case "authority":
+		return g.Authority
+	case "hub":
+		return g.Hub
+	}
+	return nil
+}
+
+// isStaticScore returns wether name is the name of a static score
+func isStaticScore(name string) bool {
+	for _, n := range staticScores {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// mixStatic multiplies the wf score of refs by 1 + mix * the static score of the documents
+// static scores are divided by their maximum so mix is the highest possible boost
+// refs must be sorted again afterwards
+func (s *Search) mixStatic(refs []Ref, wf weight, name string, mix float64) {
+	if s.Graph == nil {
+		return
+	}
+	scores := s.Graph.scores(name)
+	var max float64
+	for _, score := range scores {
+		if score > max {
+			max = score
+		}
+	}
+	if max == 0 {
+		return
+	}
+	for i, ref := range refs {
+		if ref.Id < len(scores) {
+			refs[i].Weights[wf] *= 1 + mix*scores[ref.Id]/max
+		}
+	}
+}
+
+// links returns the documents of ids that are still in the index
+func (s *Search) links(ids []int) []Result {
+	results := make([]Result, 0, len(ids))
+	segs := s.segments()
+	for _, id := range ids {
+		i := findSegment(segs, id)
+		if i == len(segs) || segs[i].isDeleted(id) {
+			continue
+		}
+		results = append(results, s.result(id))
+	}
+	return results
+}
+
+// serializeGraph saves the citation graph, if the corpus has one
+func (s *Search) serializeGraph() {
+	if s.Graph == nil {
+		return
+	}
+	file, err := createIndexFile(s.Corpus + ".graph")
+	if err != nil {
+		panic(err)
+	}
+	defer file.Close()
+	en := gob.NewEncoder(file)
+	err = en.Encode(s.Graph)
+	if err != nil {
+		panic(err)
+	}
+	file.commit()
+}
+
+// unserializeGraph loads the citation graph, corpora without one have no file
+func (s *Search) unserializeGraph() {
+	file, err := os.Open(indexFile(s.Corpus + ".graph"))
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	s.Graph = &Graph{}
+	en := gob.NewDecoder(file)
+	err = en.Decode(s.Graph)
+	if err != nil {
+		panic(err)
+	}
+	file.Close()
+}
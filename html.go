@@ -0,0 +1,456 @@
+// Html.go implements the corpora of local folders of web pages, .html and .txt files, e.g a wget mirror
+//
+//	-corpora site=html:data/mirror?base=https://
+//
+// the url of a page is base followed by its path in the folder, so with base=https:// the folders
+// of a wget mirror, named after the hosts, give back the crawled urls; without base it's a file:// url
+// the title, headings, visible text and links of html pages are extracted by a small tag scanner
+// scripts, styles and the elements holding navigation (nav, header, footer, aside, menus...) are skipped
+// links between pages of the folder are kept in the citation graph, see Graph.addLinks
+// the title of a text file is its first line
+//
+// files are indexed by goroutineNumber workers, as CS276 files, see scanConcurrently
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func init() {
+	registerCorpus("html", func(name, source string) (Corpus, error) {
+		if source == "" {
+			return nil, fmt.Errorf("html corpus %s has no source", name)
+		}
+		root, base, err := parsePagesSource(source)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		return pagesCorpus{name: name, source: source, root: root, base: base}, nil
+	})
+}
+
+// skippedElements are the elements whose content isn't visible text or is boilerplate
+var skippedElements = map[string]bool{
+	"script": true, "style": true, "noscript": true, "template": true, "svg": true,
+	"iframe": true, "nav": true, "header": true, "footer": true, "aside": true, "form": true,
+}
+
+// boilerplateNames are the classes, ids or roles of elements holding navigation
+// they also match with an s or bar suffix, as menus or navbar
+var boilerplateNames = []string{"nav", "menu", "footer", "header", "sidebar", "breadcrumb", "cookie", "banner"}
+
+// containerElements hold the content of the page, they are never boilerplate whatever their class
+var containerElements = map[string]bool{"html": true, "body": true, "main": true, "article": true}
+
+// voidElements are the elements without end tag
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+	"input": true, "link": true, "meta": true, "param": true, "source": true, "track": true, "wbr": true,
+}
+
+// inlineElements are the elements that don't separate the words around them
+var inlineElements = map[string]bool{
+	"a": true, "abbr": true, "b": true, "code": true, "em": true, "i": true, "kbd": true, "mark": true,
+	"q": true, "s": true, "small": true, "span": true, "strong": true, "sub": true, "sup": true, "u": true,
+}
+
+// page is a web page, as kept in the document store
+type page struct {
+	Title    string
+	Url      string
+	Headings []string `json:",omitempty"`
+	Text     string
+	Links    []string `json:",omitempty"`
+}
+
+// tag is a tag of an html page
+type tag struct {
+	name    string
+	attrs   map[string]string
+	closing bool
+	// selfClosing is true for tags ending with "/>"
+	selfClosing bool
+}
+
+// parseTag parses the inside of a tag, between < and >
+func parseTag(s string) tag {
+	var t tag
+	if strings.HasPrefix(s, "/") {
+		t.closing = true
+		s = s[1:]
+	}
+	if strings.HasSuffix(s, "/") {
+		t.selfClosing = true
+		s = s[:len(s)-1]
+	}
+	i := strings.IndexFunc(s, isHTMLSpace)
+	if i < 0 {
+		i = len(s)
+	}
+	t.name = strings.ToLower(s[:i])
+	t.attrs = make(map[string]string)
+	s = s[i:]
+	for {
+		s = strings.TrimLeftFunc(s, isHTMLSpace)
+		if s == "" {
+			return t
+		}
+		end := strings.IndexFunc(s, func(r rune) bool { return r == '=' || isHTMLSpace(r) })
+		if end < 0 {
+			end = len(s)
+		}
+		key := strings.ToLower(s[:end])
+		s = strings.TrimLeftFunc(s[end:], isHTMLSpace)
+		if !strings.HasPrefix(s, "=") {
+			t.attrs[key] = ""
+			continue
+		}
+		s = strings.TrimLeftFunc(s[1:], isHTMLSpace)
+		var value string
+		if s != "" && (s[0] == '"' || s[0] == '\'') {
+			end = strings.IndexByte(s[1:], s[0])
+			if end < 0 {
+				end = len(s) - 1
+			}
+			value, s = s[1:end+1], s[min(end+2, len(s)):]
+		} else {
+			end = strings.IndexFunc(s, isHTMLSpace)
+			if end < 0 {
+				end = len(s)
+			}
+			value, s = s[:end], s[end:]
+		}
+		t.attrs[key] = html.UnescapeString(value)
+	}
+}
+
+func isHTMLSpace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\n' || r == '\r' || r == '\f'
+}
+
+// isBoilerplate returns wether an element holds navigation, from its name, class, id or role
+// classes are matched whole, an article-header class isn't a header
+func (t tag) isBoilerplate() bool {
+	if skippedElements[t.name] {
+		return true
+	}
+	if containerElements[t.name] {
+		return false
+	}
+	for _, attr := range []string{"class", "id", "role"} {
+		for _, word := range strings.Fields(strings.ToLower(t.attrs[attr])) {
+			for _, name := range boilerplateNames {
+				if word == name || word == name+"s" || word == name+"bar" {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// parsePage extracts the title, headings, visible text and links of an html page
+// links are resolved against the url of the page, only web and file links are kept
+func parsePage(content, pageUrl string) page {
+	p := page{Url: pageUrl}
+	base, _ := url.Parse(pageUrl)
+	var text, title, heading strings.Builder
+	// skip is the boilerplate element being skipped, depth counts the nested elements of the same name
+	var skip string
+	var depth int
+	inTitle, inHeading := false, false
+	for len(content) > 0 {
+		lt := strings.IndexByte(content, '<')
+		if lt < 0 {
+			lt = len(content)
+		}
+		if skip == "" {
+			chunk := html.UnescapeString(content[:lt])
+			switch {
+			case inTitle:
+				title.WriteString(chunk)
+			default:
+				text.WriteString(chunk)
+				if inHeading {
+					heading.WriteString(chunk)
+				}
+			}
+		}
+		content = content[lt:]
+		if content == "" {
+			break
+		}
+		// comments and doctype
+		if strings.HasPrefix(content, "<!--") {
+			end := strings.Index(content, "-->")
+			if end < 0 {
+				break
+			}
+			content = content[end+3:]
+			continue
+		}
+		gt := strings.IndexByte(content, '>')
+		if gt < 0 {
+			break
+		}
+		raw := content[1:gt]
+		content = content[gt+1:]
+		if strings.HasPrefix(raw, "!") || strings.HasPrefix(raw, "?") {
+			continue
+		}
+		t := parseTag(raw)
+		if t.name == "" {
+			continue
+		}
+		// the content of scripts and styles isn't html, it's skipped up to their end tag
+		if !t.closing && (t.name == "script" || t.name == "style") {
+			end := strings.Index(strings.ToLower(content), "</"+t.name)
+			if end < 0 {
+				break
+			}
+			content = content[end:]
+			continue
+		}
+		if skip != "" {
+			if t.name == skip && !t.selfClosing {
+				if t.closing {
+					depth--
+				} else {
+					depth++
+				}
+				if depth == 0 {
+					skip = ""
+				}
+			}
+			continue
+		}
+		switch {
+		case t.name == "title":
+			inTitle = !t.closing
+			continue
+		case len(t.name) == 2 && t.name[0] == 'h' && t.name[1] >= '1' && t.name[1] <= '6':
+			inHeading = !t.closing
+			if t.closing {
+				if h := collapseSpaces(strings.TrimSpace(heading.String())); h != "" {
+					p.Headings = append(p.Headings, h)
+				}
+				heading.Reset()
+			}
+		case !t.closing && !voidElements[t.name] && !t.selfClosing && t.isBoilerplate():
+			skip, depth = t.name, 1
+			continue
+		case t.name == "a" && !t.closing:
+			if link := resolveLink(base, t.attrs["href"]); link != "" {
+				p.Links = append(p.Links, link)
+			}
+		}
+		if !inlineElements[t.name] {
+			text.WriteString("\n")
+		}
+	}
+	p.Title = collapseSpaces(strings.TrimSpace(title.String()))
+	p.Text = cleanLines(text.String())
+	return p
+}
+
+// resolveLink returns the absolute url of a link, without fragment, or an empty string if it isn't a web link
+func resolveLink(base *url.URL, href string) string {
+	ref, err := url.Parse(strings.TrimSpace(href))
+	if err != nil || href == "" {
+		return ""
+	}
+	if base != nil {
+		ref = base.ResolveReference(ref)
+	}
+	if ref.Scheme != "http" && ref.Scheme != "https" && ref.Scheme != "file" {
+		return ""
+	}
+	ref.Fragment = ""
+	return ref.String()
+}
+
+// cleanLines collapses the spaces of each line and drops the empty ones
+func cleanLines(text string) string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(collapseSpaces(line)); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// parseText returns a text file as a page, titled by its first line
+func parseText(content, pageUrl string) page {
+	p := page{Url: pageUrl, Text: cleanLines(content)}
+	p.Title = p.Text
+	if i := strings.IndexByte(p.Text, '\n'); i >= 0 {
+		p.Title = p.Text[:i]
+	}
+	return p
+}
+
+// parsePagesSource splits a source in its folder and the base of the urls, "folder?base=https://"
+func parsePagesSource(source string) (string, string, error) {
+	root, options := source, ""
+	if i := strings.Index(source, "?"); i >= 0 {
+		root, options = source[:i], source[i+1:]
+	}
+	values, err := url.ParseQuery(options)
+	if err != nil {
+		return "", "", err
+	}
+	for key := range values {
+		if key != "base" {
+			return "", "", fmt.Errorf("unknown html option %s", key)
+		}
+	}
+	base := values.Get("base")
+	if base == "" {
+		abs, err := filepath.Abs(root)
+		if err != nil {
+			return "", "", err
+		}
+		base = "file://" + filepath.ToSlash(abs) + "/"
+	}
+	return root, base, nil
+}
+
+// pagesCorpus is a corpus of the .html and .txt files of a folder
+type pagesCorpus struct {
+	name, source string
+	root, base   string
+}
+
+func (c pagesCorpus) Name() string   { return c.name }
+func (c pagesCorpus) Kind() string   { return "html" }
+func (c pagesCorpus) Source() string { return c.source }
+func (c pagesCorpus) Perf() Perf     { return Perf{Initial: sourceSize(c.root)} }
+
+// Scanner returns a scanner of the pages under source
+// sources of added pages without base use the base of the corpus
+func (c pagesCorpus) Scanner(source string, cw map[string]bool, trie *Root) (Scanner, error) {
+	root, base := source, c.base
+	if strings.Contains(source, "?") {
+		var err error
+		if root, base, err = parsePagesSource(source); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := os.Stat(root); err != nil {
+		return nil, err
+	}
+	return &PagesScanner{root: root, base: base, cw: cw, trie: trie, toScan: make(chan string, 100)}, nil
+}
+
+func (c pagesCorpus) Text(stored []byte) string  { return pageText(stored) }
+func (c pagesCorpus) Analyze(word string) string { return stem(word) }
+
+func (c pagesCorpus) Page(s *Search, id int) (string, interface{}, error) {
+	return pageDocument(s, id)
+}
+
+// pageText returns the visible text of a stored page
+func pageText(stored []byte) string {
+	var p page
+	json.Unmarshal(stored, &p)
+	return p.Text
+}
+
+// pageDocument returns the text of a stored page, with a link to its url and the pages of the corpus it links to
+func pageDocument(s *Search, id int) (string, interface{}, error) {
+	stored, err := s.document(id)
+	if err != nil {
+		return "", nil, err
+	}
+	var p page
+	if err := json.Unmarshal(stored, &p); err != nil {
+		return "", nil, err
+	}
+	doc := textDoc{Title: p.Title, Source: p.Url, Text: p.Text}
+	if s.Graph != nil && id >= 0 && id < len(s.Graph.Cites) {
+		doc.Cites = s.links(s.Graph.Cites[id])
+		doc.CitedBy = s.links(s.Graph.CitedBy[id])
+	}
+	return "doc", doc, nil
+}
+
+// PagesScanner indexes the .html and .txt files of a folder
+type PagesScanner struct {
+	root, base string
+	cw         map[string]bool
+	trie       *Root
+	toScan     chan string
+}
+
+// Scan sends the scanned pages to the channel using multiple goroutines to index them
+func (s *PagesScanner) Scan(ctx context.Context, c chan metadata) {
+	// goroutine walking the folder in lexical order
+	go func() {
+		filepath.Walk(s.root, func(p string, info os.FileInfo, err error) error {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err != nil {
+				return nil
+			}
+			ext := strings.ToLower(filepath.Ext(p))
+			if !info.IsDir() && (ext == ".html" || ext == ".htm" || ext == ".txt") {
+				s.toScan <- p
+			}
+			return nil
+		})
+		close(s.toScan)
+	}()
+	scanConcurrently(ctx, c, s.toScan, s.trie, s.index)
+}
+
+// index reads a page in the document
+func (s *PagesScanner) index(doc *Document, file string) error {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(s.root, file)
+	if err != nil {
+		return err
+	}
+	pageUrl := s.base + filepath.ToSlash(rel)
+	var p page
+	if strings.ToLower(filepath.Ext(file)) == ".txt" {
+		p = parseText(string(content), pageUrl)
+	} else {
+		p = parsePage(string(content), pageUrl)
+	}
+	if p.Title == "" {
+		p.Title = filepath.ToSlash(rel)
+	}
+	// the path in the folder identifies the page, whatever its base
+	doc.External = filepath.ToSlash(rel)
+	doc.Read = len(content)
+	return indexPage(doc, p, s.cw)
+}
+
+// indexPage adds the title, text and headings of a page to the document, and keeps the page as its text
+func indexPage(doc *Document, p page, cw map[string]bool) error {
+	doc.Title = p.Title
+	doc.Url = p.Url
+	doc.Links = p.Links
+	doc.addText(p.Title, titlePrefix, cw)
+	doc.addText(p.Text, "", cw)
+	// headings are part of the text, they only add their field terms
+	for _, h := range p.Headings {
+		doc.addFieldTerms(h, headingPrefix, cw)
+	}
+	var err error
+	doc.Text, err = json.Marshal(p)
+	return err
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testPage = `<!DOCTYPE html>
+<html><head><title>Compiler &amp; languages</title>
+<style>p { color: red }</style>
+<script>var s = "<p>not text</p>";</script></head>
+<body>
+<nav><a href="other.html">Home</a> menu</nav>
+<div class="top header"><div>Sorting ads</div></div>
+<h1>Algebraic <em>compilers</em></h1>
+<p>A compiler for an algebraic language, see <a href="sub/notes.txt#top">notes</a>
+and <a href="https://example.com/">example</a>.</p>
+<!-- <p>commented</p> -->
+<footer>Copyright</footer>
+</body></html>`
+
+func TestParsePage(t *testing.T) {
+	p := parsePage(testPage, "https://site.org/dir/index.html")
+	if p.Title != "Compiler & languages" {
+		t.Errorf("Title is %q", p.Title)
+	}
+	if len(p.Headings) != 1 || p.Headings[0] != "Algebraic compilers" {
+		t.Errorf("Headings are %q", p.Headings)
+	}
+	if p.Text != "Algebraic compilers\nA compiler for an algebraic language, see notes\nand example." {
+		t.Errorf("Text is %q", p.Text)
+	}
+	if strings.Join(p.Links, " ") != "https://site.org/dir/sub/notes.txt https://example.com/" {
+		t.Errorf("Links are %q", p.Links)
+	}
+}
+
+func TestBoilerplateClasses(t *testing.T) {
+	p := parsePage(`<html><body class="no-sidebar"><main class="menu">
+<div class="article-header"><h1>Compilers</h1></div>
+<div class="nav-tabs">Algebraic languages</div>
+<div class="navbar">Home</div><ul id="menus"><li>About</li></ul>
+</main></body></html>`, "https://site.org/")
+	if p.Text != "Compilers\nAlgebraic languages" {
+		t.Errorf("Text is %q", p.Text)
+	}
+}
+
+func TestPagesCorpus(t *testing.T) {
+	useTempIndexDir(t)
+	dir, err := ioutil.TempDir("", "pages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "site.org", "dir", "sub"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "site.org", "dir", "index.html"), []byte(testPage), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "site.org", "dir", "sub", "notes.txt"),
+		[]byte("Notes on sorting\n\nMerging sorted tapes, back to the compiler\n"), 0644)
+
+	corpus, err := makeCorpus("test", "html", dir+"?base=https://")
+	if err != nil {
+		t.Fatal(err)
+	}
+	search := ParseCorpus(context.Background(), corpus, map[string]bool{})
+	if search.Size != 2 {
+		t.Fatalf("%d pages indexed", search.Size)
+	}
+	refs := search.BooleanSearch("heading:compiler")
+	if len(refs) != 1 || refs[0].Name != "Compiler & languages" {
+		t.Fatalf("Heading query returned %v", refs)
+	}
+	// the boilerplate isn't indexed
+	if refs := search.BooleanSearch("copyright OR menu OR ads"); len(refs) != 0 {
+		t.Errorf("Boilerplate indexed: %v", refs)
+	}
+	notes := search.BooleanSearch("title:notes")
+	if len(notes) != 1 {
+		t.Fatalf("Text file query returned %v", notes)
+	}
+	// the link between the two pages is a citation
+	if search.Graph == nil || !containsId(search.Graph.Cites[refs[0].Id], notes[0].Id) {
+		t.Errorf("Link missing from the graph: %v", search.Graph)
+	}
+	_, data, err := corpus.Page(search, notes[0].Id)
+	if err != nil || data.(textDoc).Source != "https://site.org/dir/sub/notes.txt" {
+		t.Errorf("Page is %v, %v", data, err)
+	}
+}
@@ -0,0 +1,254 @@
+// Inspect.go implements the inspect subcommand, to look inside an index without serving it
+//
+//	rechercheInfoWeb inspect -corpus cacm -prefix comput
+//	rechercheInfoWeb inspect -corpus cacm -term comput
+//	rechercheInfoWeb inspect -corpus cs276 -stats
+//	rechercheInfoWeb inspect -corpus cacm -verify
+//
+// terms are looked up as they are stored, i.e lowercased and stemmed
+// verify re-scans the source corpus and compares it to the index,
+// documents are matched by external id since cs276 ids depend of the scan order
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// inspect runs the inspect subcommand with its arguments
+func inspect(args []string) {
+	flags := flag.NewFlagSet("inspect", flag.ExitOnError)
+	corpus := flags.String("corpus", "cacm", "-corpus name of the index to inspect")
+	segment := flags.String("segment", "", "-segment name to only inspect one segment")
+	prefix := flags.String("prefix", "", "-prefix p to list the terms starting with p")
+	term := flags.String("term", "", "-term t to print the postings of t")
+	stats := flags.Bool("stats", false, "-stats to print the shape of the tries")
+	verify := flags.Bool("verify", false, "-verify to compare the index with a new scan of the corpus")
+	source := flags.String("source", "", "-source path of the corpus for -verify, defaults to the source of the corpus")
+	limit := flags.Int("limit", 50, "-limit n maximum number of terms, postings or differences printed")
+	flags.StringVar(&indexDir, "indexes", "indexes", "-indexes dir to use another folder for the indexes")
+	flags.Parse(args)
+
+	search, err := UnserializeSearch(*corpus)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	segs := search.Segments
+	if *segment != "" {
+		segs = nil
+		for _, seg := range search.Segments {
+			if seg.Name == *segment {
+				segs = append(segs, seg)
+			}
+		}
+		if len(segs) == 0 {
+			fmt.Fprintf(os.Stderr, "%s has no segment %s\n", *corpus, *segment)
+			os.Exit(1)
+		}
+	}
+
+	fmt.Printf("%s: %d documents, %d segments\n", search.Corpus, search.Size, len(search.Segments))
+	if *prefix != "" {
+		printTerms(segs, *prefix, *limit)
+	}
+	if *term != "" {
+		printPostings(segs, *term, *limit)
+	}
+	if *stats {
+		for _, seg := range segs {
+			printShape(seg)
+		}
+	}
+	if *verify {
+		if verifyIndex(search, *source, *limit) > 0 {
+			os.Exit(1)
+		}
+	}
+}
+
+// printTerms lists the terms starting with prefix and their document frequency
+func printTerms(segs []*Segment, prefix string, limit int) {
+	df := make(map[string]int)
+	for _, seg := range segs {
+		seg.Index.walk(func(w string, refs []Ref) {
+			if strings.HasPrefix(w, prefix) {
+				df[w] += len(seg.get(w))
+			}
+		})
+	}
+	terms := make([]string, 0, len(df))
+	for w := range df {
+		terms = append(terms, w)
+	}
+	sort.Strings(terms)
+	fmt.Printf("%d terms starting with %q\n", len(terms), prefix)
+	for i, w := range terms {
+		if i == limit {
+			fmt.Printf("... %d more\n", len(terms)-limit)
+			break
+		}
+		fmt.Printf("%s\t%d\n", w, df[w])
+	}
+}
+
+// printPostings prints the references of a term in all segments
+func printPostings(segs []*Segment, term string, limit int) {
+	var printed int
+	for _, seg := range segs {
+		refs := seg.Index.get(term)
+		fmt.Printf("%s: %d postings for %q\n", seg.Name, len(refs), term)
+		for _, ref := range refs {
+			if printed == limit {
+				fmt.Println("...")
+				return
+			}
+			printed++
+			var deleted string
+			if seg.isDeleted(ref.Id) {
+				deleted = "\tdeleted"
+			}
+			fmt.Printf("%d\t%s\t%s %g\t%s %g\t%s %g%s\n",
+				ref.Id, strings.TrimSpace(seg.Titles[ref.Id-seg.First]),
+				weightName[raw], ref.Weights[raw],
+				weightName[norm], ref.Weights[norm],
+				weightName[half], ref.Weights[half],
+				deleted)
+		}
+	}
+}
+
+// printShape prints the statistics of a segment trie
+func printShape(seg *Segment) {
+	st := seg.Index.shape()
+	fmt.Printf("%s: documents %d, deleted %d, terms %d, postings %d, nodes %d, leaves %d\n",
+		seg.Name, len(seg.Titles), seg.Deleted.count(), st.Terms, st.Postings, st.Nodes, st.Leaves)
+	fmt.Printf("average sons count for non leaf node %f\n", seg.Index.getAverageSonsCount())
+	fmt.Println("depth\tnodes")
+	for depth, count := range st.Depth {
+		fmt.Printf("%d\t%d\n", depth, count)
+	}
+	fmt.Println("sons\tnodes")
+	for sons, count := range st.FanOut {
+		if count > 0 {
+			fmt.Printf("%d\t%d\n", sons, count)
+		}
+	}
+}
+
+// posting is a reference identified by the external id of its document, to compare indexes
+type posting struct {
+	external string
+	weights  weights
+}
+
+// externalPostings returns the postings of refs sorted by external id
+// only the documents of keep are returned
+func externalPostings(s *Search, refs []Ref, keep map[string]int) []posting {
+	postings := make([]posting, 0, len(refs))
+	for _, ref := range refs {
+		external := s.externalId(ref.Id)
+		if keep[external] > 0 {
+			postings = append(postings, posting{external, ref.Weights})
+		}
+	}
+	sort.Slice(postings, func(i, j int) bool {
+		if postings[i].external != postings[j].external {
+			return postings[i].external < postings[j].external
+		}
+		return postings[i].weights[raw] < postings[j].weights[raw]
+	})
+	return postings
+}
+
+// liveExternals returns the external ids of the documents not deleted, with their number of occurences
+func liveExternals(s *Search) map[string]int {
+	externals := make(map[string]int)
+	for _, seg := range s.Segments {
+		for i, external := range seg.Externals {
+			if !seg.Deleted.has(i) {
+				externals[external]++
+			}
+		}
+	}
+	return externals
+}
+
+// verifyIndex scans the source of the corpus again and compares it with the index
+// it prints the differences and returns their number
+// documents added or deleted after the indexing are reported, then ignored for postings
+func verifyIndex(index *Search, source string, limit int) int {
+	if index.corpus == nil {
+		fmt.Fprintf(os.Stderr, "no scanner for corpus %s\n", index.Corpus)
+		return 1
+	}
+	if source == "" {
+		source = index.corpus.Source()
+	}
+	corpus, err := makeCorpus(index.Corpus, index.corpus.Kind(), source)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	scan := ParseCorpus(context.Background(), corpus, index.CW)
+
+	var diffs int
+	report := func(format string, a ...interface{}) {
+		if diffs < limit {
+			fmt.Printf(format, a...)
+		} else if diffs == limit {
+			fmt.Println("...")
+		}
+		diffs++
+	}
+
+	indexed, scanned := liveExternals(index), liveExternals(scan)
+	common := make(map[string]int)
+	for external, n := range scanned {
+		if indexed[external] != n {
+			report("document %q: %d in the index, %d in the source\n", external, indexed[external], n)
+		}
+		if indexed[external] > 0 {
+			common[external] = n
+		}
+	}
+	for external, n := range indexed {
+		if scanned[external] == 0 {
+			report("document %q: %d in the index, not in the source\n", external, n)
+		}
+	}
+
+	var docs, terms int
+	for _, n := range common {
+		docs += n
+	}
+	scan.Segments[0].Index.walk(func(w string, refs []Ref) {
+		terms++
+		want := externalPostings(scan, refs, common)
+		got := externalPostings(index, index.get(w), common)
+		if len(want) != len(got) {
+			report("term %q: %d postings in the index, %d in the source\n", w, len(got), len(want))
+			return
+		}
+		for i := range want {
+			if want[i] != got[i] {
+				report("term %q: document %q has weights %v in the index, %v in the source\n",
+					w, want[i].external, got[i].weights, want[i].weights)
+				return
+			}
+		}
+	})
+	for _, seg := range index.Segments {
+		seg.Index.walk(func(w string, refs []Ref) {
+			if len(scan.get(w)) == 0 && len(externalPostings(index, seg.get(w), common)) > 0 {
+				report("term %q: in the index, not in the source\n", w)
+			}
+		})
+	}
+	fmt.Printf("verified %d documents and %d terms: %d differences\n", docs, terms, diffs)
+	return diffs
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestInspect(t *testing.T) {
+	useTempIndexDir(t)
+	source := filepath.Join(t.TempDir(), "test.all")
+	ioutil.WriteFile(source, []byte(testCACM), 0644)
+	corpus, err := makeCorpus("test", "cacm", source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ParseCorpus(context.Background(), corpus, map[string]bool{}).Serialize()
+	search, err := UnserializeSearch("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the shape counts every node once
+	st := search.Segments[0].Index.shape()
+	var terms, postings, depths, fanOuts int
+	search.Segments[0].Index.walk(func(w string, refs []Ref) {
+		terms++
+		postings += len(refs)
+	})
+	for _, n := range st.Depth {
+		depths += n
+	}
+	for _, n := range st.FanOut {
+		fanOuts += n
+	}
+	if st.Terms != terms || st.Postings != postings || st.Depth[0] != 1 || depths != st.Nodes || fanOuts != st.Nodes {
+		t.Errorf("Incorrect shape %+v for %d terms and %d postings", st, terms, postings)
+	}
+
+	if diffs := verifyIndex(search, "", 10); diffs != 0 {
+		t.Errorf("%d differences with the source indexed", diffs)
+	}
+	// documents added to the source since are reported
+	ioutil.WriteFile(source, []byte(testCACM+testCACMAddition), 0644)
+	if diffs := verifyIndex(search, "", 10); diffs != 2 {
+		t.Errorf("%d differences instead of the 2 documents added", diffs)
+	}
+}
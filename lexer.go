@@ -1,95 +1,340 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
+	"os"
+	"path/filepath"
 	"strings"
 	"time"
 )
 
 type metadata struct {
-	id     int
-	tokens int
-	title  string
+	id       int
+	tokens   int
+	title    string
+	external string
+	// cites are the external ids of the documents cited
+	cites []string
+	// date is the publication date, see documentDate
+	date int
+	// text is the text of the document, kept in the document store
+	text []byte
+	// url is the url of a web page, links the urls it links to
+	url   string
+	links []string
+	// read is the number of bytes of the source read, see progress
+	read int
 }
 
 func metadataFromDoc(d *Document) metadata {
 	return metadata{
-		id:     d.Id,
-		tokens: d.Tokens,
-		title:  d.Title,
+		id:       d.Id,
+		tokens:   d.Tokens,
+		title:    d.Title,
+		external: d.External,
+		cites:    d.Cites,
+		date:     d.Date,
+		text:     d.Text,
+		url:      d.Url,
+		links:    d.Links,
+		read:     d.Read,
 	}
 }
 
-// cacmToUrl generates url from cacm id
-func cacmToUrl(id int, title string) string {
-	return fmt.Sprintf("/cacm/%d", id)
+func init() {
+	registerCorpus("cacm", func(name, source string) (Corpus, error) {
+		if source == "" {
+			source = cacmFile
+		}
+		return cacmCorpus{name: name, source: source}, nil
+	})
+	registerCorpus("cs276", func(name, source string) (Corpus, error) {
+		if source == "" {
+			source = cs276File
+		}
+		return cs276Corpus{name: name, source: source}, nil
+	})
+}
+
+// cacmCorpus is a corpus of documents in the cacm.all format
+type cacmCorpus struct {
+	name, source string
+}
+
+func (c cacmCorpus) Name() string   { return c.name }
+func (c cacmCorpus) Kind() string   { return "cacm" }
+func (c cacmCorpus) Source() string { return c.source }
+func (c cacmCorpus) Perf() Perf     { return newCACMPerf() }
+
+// Scanner reads the whole file, it's small enough and the scanner doesn't have to close it
+func (c cacmCorpus) Scanner(source string, cw map[string]bool, trie *Root) (Scanner, error) {
+	text, err := ioutil.ReadFile(source)
+	if err != nil {
+		return nil, err
+	}
+	return NewCACMScanner(bytes.NewReader(text), cw, trie), nil
+}
+
+// Judgments returns the -topics and -qrels files, which judge the documents of cacm.all only
+func (c cacmCorpus) Judgments() (string, string) {
+	if filepath.Clean(c.source) != filepath.Clean(cacmFile) {
+		return "", ""
+	}
+	return topicsFile, qrelsFile
+}
+
+func (c cacmCorpus) Text(stored []byte) string  { return cacmText(stored) }
+func (c cacmCorpus) Analyze(word string) string { return stem(word) }
+
+// Page returns the structured cacm page of a document, with the documents it cites and the ones citing it
+func (c cacmCorpus) Page(s *Search, id int) (string, interface{}, error) {
+	doc, err := cacmDocument(s, id)
+	if err != nil {
+		return "", nil, err
+	}
+	if s.Graph != nil && id >= 0 && id < len(s.Graph.Cites) {
+		doc.Cites = s.links(s.Graph.Cites[id])
+		doc.CitedBy = s.links(s.Graph.CitedBy[id])
+	}
+	return "cacm", doc, nil
+}
+
+// cs276Corpus is a corpus of web pages stored one per file, in the layout of the cs276 data folder
+type cs276Corpus struct {
+	name, source string
+}
+
+func (c cs276Corpus) Name() string   { return c.name }
+func (c cs276Corpus) Kind() string   { return "cs276" }
+func (c cs276Corpus) Source() string { return c.source }
+func (c cs276Corpus) Perf() Perf     { return newCS276Perf() }
+
+func (c cs276Corpus) Scanner(source string, cw map[string]bool, trie *Root) (Scanner, error) {
+	if _, err := os.Stat(source); err != nil {
+		return nil, err
+	}
+	return NewCS276Scanner(source, trie), nil
+}
+
+func (c cs276Corpus) Text(stored []byte) string  { return string(stored) }
+func (c cs276Corpus) Analyze(word string) string { return stem(word) }
+
+// Page returns the stored text of the document, with a link to the crawled page
+func (c cs276Corpus) Page(s *Search, id int) (string, interface{}, error) {
+	text, err := s.document(id)
+	if err != nil {
+		return "", nil, err
+	}
+	title := s.title(id)
+	return "doc", textDoc{Title: title, Source: cs276Source(title), Text: string(text)}, nil
 }
 
 // Replacer is used to remplace _ by / in filename and get url
 var replacer = strings.NewReplacer("_", "/")
 
-// cs276ToUrl generates url from cs276 doc title (i.e file name)
-func cs276ToUrl(id int, title string) string {
+// cs276Source returns the url the cs276 document was crawled from
+func cs276Source(title string) string {
 	return "https://" + replacer.Replace(title[2:]) // removes the [0-9]/ part of the title
 }
 
+// ParseCorpus indexes the documents of a corpus, read from its source
+// the trie is written to runs on disk when it outgrows -memory, see spimi.go
+// when ctx is cancelled the scan stops and the search returned is incomplete, it mustn't be saved
+func ParseCorpus(ctx context.Context, corpus Corpus, cw map[string]bool) *Search {
+	peak := sampleMemory()
+	// index stored in a prefix trie
+	trie := NewTrie()
+	trie.spillTo(corpus.Name(), int64(memoryBudget)<<20)
+	scanner, err := corpus.Scanner(corpus.Source(), cw, trie)
+	if err != nil {
+		panic(err)
+	}
+	search := emptySearch(corpus.Name(), cw)
+	search.corpus = corpus
+	search.Perf = corpus.Perf()
+	search.Segments = []*Segment{newSegment(search.Corpus, trie)}
+
+	// chan for processed documents
+	// metadata are handled in the main thread
+	c := make(chan metadata, 100)
+	p := startProgress(corpus.Name(), sourceSize(corpus.Source()))
+	go scanner.Scan(ctx, c)
+	buildSearchFromScanner(ctx, search, c, p)
+	if r, ok := scanner.(resumer); ok {
+		search.Resume = r.positions()
+	}
+	search.Perf.Memory = peak()
+	return search
+}
+
 // ParseCACM creates a cacm scanner, a search struct and connects them
 func ParseCACM(r io.Reader, cw map[string]bool) *Search {
 	// index stored in a prefix trie
 	trie := NewTrie()
 	cacm := NewCACMScanner(r, cw, trie)
 
-	c := make(chan metadata)
-	go cacm.Scan(c)
-
 	search := emptySearch("cacm", cw)
-	search.toUrl = cacmToUrl
+	search.corpus = cacmCorpus{name: "cacm", source: cacmFile}
 	search.Perf = newCACMPerf()
-	search.Index = trie
-	return buildSearchFromScanner(search, c)
+	search.Segments = []*Segment{newSegment(search.Corpus, trie)}
+
+	c := make(chan metadata)
+	go cacm.Scan(context.Background(), c)
+	return buildSearchFromScanner(context.Background(), search, c, startProgress(search.Corpus, 0))
 }
 
-// ParseCS276 creates a parser struct from the root folder of cs216 data
-func ParseCS276(root string, cw map[string]bool) *Search {
-	// index stored in a prefix trie
-	trie := NewTrie()
-	cs276 := NewCS276Scanner(root, trie)
-	// chan for processed documents
-	// metadata are handled in the main thread
+// AppendCorpus indexes the documents at source in a new segment of search
+// source must have the format of the corpus of the search
+// when ctx is cancelled the scan stops and the segment is dropped, the error is then the one of ctx
+func AppendCorpus(ctx context.Context, search *Search, source string) error {
+	if search.corpus == nil {
+		return fmt.Errorf("%s has no known corpus kind to read %s", search.Corpus, source)
+	}
+	seg := search.newSegment()
+	seg.Index.spillTo(seg.Name, int64(memoryBudget)<<20)
+	scanner, err := search.corpus.Scanner(source, search.CW, seg.Index)
+	if err != nil {
+		return err
+	}
+
+	if r, ok := scanner.(resumer); ok {
+		r.resume(search.Resume)
+	}
+
 	c := make(chan metadata, 100)
-	go cs276.Scan(c)
+	p := startProgress(search.Corpus, sourceSize(source))
+	go scanner.Scan(ctx, c)
+	appendFromScanner(ctx, search, seg, c, scanner, p)
+	return ctx.Err()
+}
+
+// AppendCACM indexes the cacm formatted documents of r in a new segment of search
+func AppendCACM(search *Search, r io.Reader) {
+	seg := search.newSegment()
+	cacm := NewCACMScanner(r, search.CW, seg.Index)
 
-	search := emptySearch("cs276", cw)
-	search.toUrl = cs276ToUrl
-	search.Perf = newCS276Perf()
-	search.Index = trie
-	return buildSearchFromScanner(search, c)
+	c := make(chan metadata)
+	go cacm.Scan(context.Background(), c)
+	appendFromScanner(context.Background(), search, seg, c, cacm, startProgress(search.Corpus, 0))
 }
 
-func buildSearchFromScanner(search *Search, c chan metadata) *Search {
+// appendFromScanner adds the documents indexed in seg to search
+// the segment is saved, then added to the search
+// Heaps law values are kept from the initial indexing, other stats are updated
+// the citation graph isn't updated, the links of added documents are ignored
+// the positions of resumable scanners are saved with the stats, once the segment is serialized
+// nothing is saved when ctx is cancelled
+func appendFromScanner(ctx context.Context, search *Search, seg *Segment, c chan metadata, scanner Scanner, p *progress) {
+	now := time.Now()
+	var tokens int
+	for doc := range c {
+		seg.AddDocMetaData(doc)
+		tokens += doc.tokens
+		p.add(doc)
+	}
+	if ctx.Err() != nil {
+		seg.Index.discard()
+		p.done("interrupted")
+		return
+	}
+	p.setState("merging")
+	seg.Index.finish()
+	p.done("done")
+	r, resumable := scanner.(resumer)
+	if resumable {
+		search.Resume = r.positions()
+	}
+	if len(seg.Titles) == 0 {
+		log.Printf("%s no documents to add \n", search.Corpus)
+		if resumable {
+			// the records read were all skipped, they don't have to be read again
+			search.serializeMeta()
+		}
+		return
+	}
+	// must be done before the segment is added to the search
+	search.Stat.Vocabulary += search.newTerms(seg.Index)
+	seg.Serialize()
+	search.addSegment(seg)
+	search.Size += len(seg.Titles)
+	search.Stat.Documents = search.Size
+	search.Stat.Tokens += tokens
+	search.serializeMeta()
+	log.Printf("%s %d documents added in %s \n", search.Corpus, len(seg.Titles), time.Since(now).String())
+}
+
+// buildSearchFromScanner adds the documents indexed to search, p reports the progress of the build
+// when ctx is cancelled the documents scanned are only counted, the index isn't completed
+func buildSearchFromScanner(ctx context.Context, search *Search, c chan metadata, p *progress) *Search {
 	now := time.Now()
 
 	// The main loop get parsed documents and deals with metadata
+	seg := search.Segments[0]
+	// links between web pages are resolved once all urls are known, citations once all external ids are
+	urls := make(map[string]int)
+	links := make(map[int][]string)
+	externals := make(map[string]int)
+	cites := make(map[int][]string)
 	for doc := range c {
-		search.AddDocMetaData(doc)
+		p.add(doc)
+		seg.AddDocMetaData(doc)
+		search.addTokens(doc)
+		if old, ok := externals[doc.external]; doc.external != "" && (!ok || doc.id < old) {
+			externals[doc.external] = doc.id
+		}
+		if len(doc.cites) > 0 {
+			cites[doc.id] = doc.cites
+		}
+		// a url read twice is the page of its first document, whatever the order of the channel
+		if old, ok := urls[doc.url]; doc.url != "" && (!ok || doc.id < old) {
+			urls[doc.url] = doc.id
+		}
+		if len(doc.links) > 0 {
+			links[doc.id] = doc.links
+		}
+	}
+	if ctx.Err() != nil {
+		seg.Index.discard()
+		p.done("interrupted")
+		return search
+	}
+	p.setState("merging")
+	if len(cites) > 0 {
+		if search.Graph == nil {
+			search.Graph = &Graph{}
+		}
+		search.Graph.addLinks(externals, cites)
+	}
+	if len(links) > 0 {
+		if search.Graph == nil {
+			search.Graph = &Graph{}
+		}
+		search.Graph.addLinks(urls, links)
 	}
 	search.Size = len(search.Tokens)
+	if search.Graph != nil {
+		search.Graph.rank(search.Size)
+	}
 	// potentially, the index is not finished so time is innacurate
 	// the mutex protects from incorrect read though
 	search.Perf.Parsing = time.Since(now)
 	log.Printf("%s parsed in  %s \n", search.Corpus, time.Since(now).String())
 
+	// the private indexes of the workers, or the runs written to disk, are merged in the final index
 	now = time.Now()
-	// Now that all documents are known, we can fully calculate tf-idf
-	search.Index.calculateIDF(search.Size)
-	search.Perf.IDF = time.Since(now)
-	log.Printf("%s IDF calculated in  %s \n", search.Corpus, time.Since(now).String())
+	search.Perf.Runs = seg.Index.finish()
+	search.Perf.Indexing = time.Since(now)
+	log.Printf("%s index merged in %s \n", search.Corpus, time.Since(now).String())
+	p.done("done")
 
 	log.Printf("%s index average sons count for non leaf node %f\n",
 		search.Corpus,
-		search.Index.getAverageSonsCount())
+		seg.Index.getAverageSonsCount())
 
 	search.Stat = getStat(search)
 	search.Perf.Name = search.Corpus
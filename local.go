@@ -0,0 +1,131 @@
+// Local.go implements the private indexes of the workers indexing documents concurrently
+// adding a posting to the shared trie locks a node on each level, and the top levels are on the path of most words
+// so the workers of scanConcurrently wait on each other there; instead each worker adds its documents
+// to its own map of postings, without locks, and only takes their ids from the trie
+// once the scan is done the private indexes are merged in the trie, each term being inserted once
+// terms are shared out by their first byte between goroutines, which only meet at the root of the trie
+package main
+
+import "sync"
+
+// sharedTrie is true when workers add their documents to the shared trie instead of private indexes
+var sharedTrie bool
+
+// docIndex is what a worker adds its documents to, the trie or a private index
+type docIndex interface {
+	addDocInTurn(doc *Document, t turn)
+}
+
+// localIndex is the private index of a worker, the postings of its documents by first byte and term
+// the postings of a term are sorted by id as the worker takes the ids of its documents one after the other
+type localIndex struct {
+	root     *Root
+	postings [256]map[string][]Ref
+}
+
+// worker returns the index a worker adds its documents to, a private index merged by finish
+// the trie itself when it's written to runs, runs must hold all the postings of their documents, or with -shared
+func (r *Root) worker() docIndex {
+	if sharedTrie || r.spill != nil {
+		return r
+	}
+	l := &localIndex{root: r}
+	r.mu.Lock()
+	r.locals = append(r.locals, l)
+	r.mu.Unlock()
+	return l
+}
+
+// addDocInTurn adds a document once the documents read before it have their ids, see turn
+func (l *localIndex) addDocInTurn(doc *Document, t turn) {
+	t.wait()
+	l.root.newId(doc)
+	t.pass()
+	addScores(doc, func(w string, score weights) {
+		b := firstByte(w)
+		if l.postings[b] == nil {
+			l.postings[b] = make(map[string][]Ref)
+		}
+		l.postings[b][w] = append(l.postings[b][w], Ref{doc.Id, score})
+	})
+}
+
+// firstByte returns the shard of a term, the empty term is with the ones starting by 0
+func firstByte(w string) byte {
+	if w == "" {
+		return 0
+	}
+	return w[0]
+}
+
+// finish completes the trie once all its documents are added
+// the private indexes then the runs are merged in it, it returns the number of runs merged
+func (r *Root) finish() int {
+	r.mergeLocals()
+	return r.mergeRuns()
+}
+
+// mergeLocals adds the postings of the private indexes to the trie, goroutineNumber first bytes at a time
+func (r *Root) mergeLocals() {
+	locals := r.locals
+	r.locals = nil
+	if len(locals) == 0 {
+		return
+	}
+	shards := make(chan int, 256)
+	for b := 0; b < 256; b++ {
+		shards <- b
+	}
+	close(shards)
+	var wg sync.WaitGroup
+	for i := 0; i < goroutineNumber; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for b := range shards {
+				r.mergeShard(locals, b)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// mergeShard adds the terms starting by b of the private indexes, which are then freed
+func (r *Root) mergeShard(locals []*localIndex, b int) {
+	// parts are the postings of each term in the private indexes
+	parts := make(map[string][][]Ref)
+	for _, l := range locals {
+		for w, refs := range l.postings[b] {
+			parts[w] = append(parts[w], refs)
+		}
+		l.postings[b] = nil
+	}
+	for w, lists := range parts {
+		r.addRefs(w, mergeRefs(lists))
+	}
+}
+
+// mergeRefs merges lists of refs sorted by id, two by two
+func mergeRefs(lists [][]Ref) []Ref {
+	for len(lists) > 1 {
+		merged := lists[:0]
+		for i := 0; i < len(lists); i += 2 {
+			if i+1 == len(lists) {
+				merged = append(merged, lists[i])
+				break
+			}
+			a, b := lists[i], lists[i+1]
+			refs := make([]Ref, 0, len(a)+len(b))
+			for len(a) > 0 && len(b) > 0 {
+				if a[0].Id < b[0].Id {
+					refs, a = append(refs, a[0]), a[1:]
+				} else {
+					refs, b = append(refs, b[0]), b[1:]
+				}
+			}
+			merged = append(merged, append(append(refs, a...), b...))
+		}
+		lists = merged
+	}
+	return lists[0]
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// benchDocs returns n documents of 100 terms drawn from a zipfian vocabulary, as the words of a text
+func benchDocs(n int) []*Document {
+	rnd := rand.New(rand.NewSource(1))
+	vocabulary := make([]string, 50000)
+	for i := range vocabulary {
+		w := make([]byte, 3+rnd.Intn(8))
+		for j := range w {
+			w[j] = byte('a' + rnd.Intn(26))
+		}
+		vocabulary[i] = string(w)
+	}
+	zipf := rand.NewZipf(rnd, 1.1, 1, uint64(len(vocabulary)-1))
+	docs := make([]*Document, n)
+	for i := range docs {
+		docs[i] = newDocument()
+		for j := 0; j < 100; j++ {
+			docs[i].addTerm(vocabulary[zipf.Uint64()])
+		}
+	}
+	return docs
+}
+
+// indexDocs indexes docs as scanned by goroutineNumber workers, in private indexes or in the shared trie
+func indexDocs(docs []*Document, shared bool) *Root {
+	defer func(old bool) { sharedTrie = old }(sharedTrie)
+	sharedTrie = shared
+	trie := NewTrie()
+	toScan := make(chan string, 100)
+	go func() {
+		for i := range docs {
+			toScan <- strconv.Itoa(i)
+		}
+		close(toScan)
+	}()
+	c := make(chan metadata, 100)
+	scanConcurrently(context.Background(), c, toScan, trie, func(doc *Document, item string) error {
+		i, _ := strconv.Atoi(item)
+		doc.Words = append(doc.Words, docs[i].Words...)
+		doc.Count = append(doc.Count, docs[i].Count...)
+		return nil
+	})
+	for range c {
+	}
+	trie.finish()
+	return trie
+}
+
+func TestLocalIndexes(t *testing.T) {
+	docs := benchDocs(500)
+	type entry struct {
+		term string
+		refs []Ref
+	}
+	entries := func(trie *Root) []entry {
+		var entries []entry
+		trie.walk(func(w string, refs []Ref) {
+			entries = append(entries, entry{w, refs})
+		})
+		return entries
+	}
+	shared, local := entries(indexDocs(docs, true)), entries(indexDocs(docs, false))
+	if len(local) == 0 || !reflect.DeepEqual(shared, local) {
+		t.Fatalf("Private indexes merged in %d terms instead of %d", len(local), len(shared))
+	}
+	for _, e := range local {
+		for i := 1; i < len(e.refs); i++ {
+			if e.refs[i-1].Id >= e.refs[i].Id {
+				t.Fatalf("Refs of %s are not ordered by id", e.term)
+			}
+		}
+	}
+}
+
+// the benchmarks index 5000 documents, run them with -cpu 1,4,8 to see how workers contend on the shared trie
+func BenchmarkSharedTrie(b *testing.B) {
+	docs := benchDocs(5000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		indexDocs(docs, true)
+	}
+}
+
+func BenchmarkLocalIndexes(b *testing.B) {
+	docs := benchDocs(5000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		indexDocs(docs, false)
+	}
+}
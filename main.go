@@ -2,11 +2,19 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"flag"
+	"fmt"
 	"image/color"
+	"io/ioutil"
 	"log"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
 	"path"
+	"strings"
+	"syscall"
 
 	"github.com/gonum/plot"
 	"github.com/gonum/plot/plotter"
@@ -15,6 +23,23 @@ import (
 
 var buildIndex, buildPrecall bool
 
+// addDocs is a corpus:path pair of documents to add to a corpus index
+// deleteDocs is a corpus:id,id list of documents to delete
+// replaceDocs is a corpus:id:path, the document is deleted and the ones at path added
+var addDocs, deleteDocs, replaceDocs string
+
+// indexDir is the folder holding the indexes, addr the address to serve on
+var indexDir, addr string
+
+// topicsFile and qrelsFile are the test collection used to evaluate cacm
+var topicsFile, qrelsFile string
+
+// coordinatorShards is a comma separated list of shard urls, when set riw runs as a coordinator
+var coordinatorShards string
+
+// boosts is a field=boost list replacing the default boosts of fields, see fieldBoosts
+var boosts string
+
 const (
 	graphs         = "graphs"
 	cacmFile       = "data/CACM/cacm.all"
@@ -25,11 +50,186 @@ const (
 func init() {
 	flag.BoolVar(&buildIndex, "index", false, "-index to build index from scratch")
 	flag.BoolVar(&buildPrecall, "precall", false, "-precall to rebuild precision/recall data")
+	flag.StringVar(&addDocs, "add", "", "-add corpus:path to index the documents at path in a new segment of corpus")
+	flag.StringVar(&deleteDocs, "delete", "", "-delete corpus:id,id to delete documents from corpus, by external id")
+	flag.StringVar(&replaceDocs, "replace", "", "-replace corpus:id:path to replace a document, by external id, by the ones at path")
+	flag.StringVar(&indexDir, "indexes", "indexes", "-indexes dir to use another folder for the indexes")
+	flag.BoolVar(&orderedIds, "ordered", true, "-ordered=false to give ids to documents as workers finish them, builds aren't reproducible then")
+	flag.IntVar(&memoryBudget, "memory", 0, "-memory mb to write the index being built to disk each time it reaches mb megabytes, the parts are merged at the end")
+	flag.BoolVar(&sharedTrie, "shared", false, "-shared to have indexing workers add documents to the shared trie instead of private indexes merged at the end")
+	flag.StringVar(&addr, "addr", ":8080", "-addr host:port to serve on")
+	flag.StringVar(&topicsFile, "topics", "data/CACM/query.text", "-topics file of queries, CACM or TREC topics, used with -precall")
+	flag.StringVar(&qrelsFile, "qrels", "data/CACM/qrels.text", "-qrels file of relevance judgments, CACM or TREC qrels, used with -precall")
+	flag.IntVar(&shardCount, "shards", 1, "-shards n to split the corpora in n shards when building the index")
+	flag.IntVar(&shardIndex, "shard", 0, "-shard i to only keep the ith shard when building the index")
+	flag.StringVar(&partition, "partition", "range", "-partition range|hash to split shards by id range or external id hash")
+	flag.StringVar(&boosts, "boost", "", "-boost title=1,keyword=1 boosts of the fields in which plain query words are also searched")
+	flag.StringVar(&staticScore, "static", "", "-static pagerank|authority|hub static score of the citation graph mixed into vector queries")
+	flag.Float64Var(&staticMix, "mix", 0.2, "-mix weight of the static score, the highest boost of a document score")
+	flag.StringVar(&corporaList, "corpora", defaultCorpora, "-corpora name[=kind[:source]],... corpora to index and serve, kinds are cacm, cs276, jsonl, csv, html, warc and trec")
+	flag.StringVar(&coordinatorShards, "coordinator", "", "-coordinator url,url to serve queries by fanning them out to shards")
+}
+
+// indexFile returns the path of a file in the index folder
+func indexFile(name string) string {
+	return path.Join(indexDir, name)
+}
+
+// indexWriter writes a file of the index folder to a temporary file, renamed over it by commit
+// so the file is either the previous one or the complete new one, even if riw stops while writing it
+// Close removes the temporary file when the file isn't committed, e.g when writing panics
+type indexWriter struct {
+	*os.File
+	name      string
+	committed bool
+}
+
+// createIndexFile creates a file of the index folder, to be committed once written
+func createIndexFile(name string) (*indexWriter, error) {
+	file, err := ioutil.TempFile(indexDir, name+".tmp")
+	if err != nil {
+		return nil, err
+	}
+	return &indexWriter{File: file, name: indexFile(name)}, nil
+}
+
+// commit closes the file and puts it in place
+func (w *indexWriter) commit() {
+	if err := w.File.Close(); err != nil {
+		panic(err)
+	}
+	if err := os.Rename(w.File.Name(), w.name); err != nil {
+		panic(err)
+	}
+	w.committed = true
+}
+
+func (w *indexWriter) Close() error {
+	if w.committed {
+		return nil
+	}
+	w.File.Close()
+	return os.Remove(w.File.Name())
+}
+
+// corpusTarget returns the part of a corpus:value flag after the corpus
+// or an empty string if the flag is for another corpus
+func corpusTarget(value, corpus string) string {
+	i := strings.Index(value, ":")
+	if i < 0 || value[:i] != corpus {
+		return ""
+	}
+	return value[i+1:]
+}
+
+// updateIndex applies the -add, -delete and -replace flags to search
+func updateIndex(ctx context.Context, search *Search) {
+	add := func(p string) {
+		if err := AppendCorpus(ctx, search, p); err != nil {
+			log.Println(err)
+		}
+	}
+	if p := corpusTarget(addDocs, search.Corpus); p != "" {
+		log.Printf("Adding %s to %s index\n", p, search.Corpus)
+		add(p)
+	}
+	if ids := corpusTarget(deleteDocs, search.Corpus); ids != "" {
+		for _, id := range strings.Split(ids, ",") {
+			deleteDoc(search, id)
+		}
+	}
+	if r := corpusTarget(replaceDocs, search.Corpus); r != "" {
+		i := strings.Index(r, ":")
+		if i < 0 {
+			log.Println("-replace expects corpus:id:path")
+			return
+		}
+		log.Printf("Replacing %s document %s by %s\n", search.Corpus, r[:i], r[i+1:])
+		if err := replaceDoc(ctx, search, r[:i], r[i+1:]); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// replaceDoc replaces a document, from its external id, by the documents at source
+// the document is only deleted once its replacement is indexed
+func replaceDoc(ctx context.Context, search *Search, id, source string) error {
+	old, ok := search.docId(id)
+	if !ok {
+		return fmt.Errorf("%s has no document %s", search.Corpus, id)
+	}
+	next := search.nextID()
+	if err := AppendCorpus(ctx, search, source); err != nil {
+		return err
+	}
+	if search.nextID() == next {
+		return fmt.Errorf("no document read from %s, %s document %s is kept", source, search.Corpus, id)
+	}
+	return search.Delete(old)
+}
+
+// deleteDoc deletes a document from its external id, the one of its url, it returns false if it failed
+func deleteDoc(search *Search, id string) bool {
+	n, ok := search.docId(id)
+	if !ok {
+		log.Printf("%s has no document %s\n", search.Corpus, id)
+		return false
+	}
+	if err := search.Delete(n); err != nil {
+		log.Println(err)
+		return false
+	}
+	return true
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "inspect":
+			inspect(os.Args[2:])
+			return
+		case "eval":
+			evaluation(os.Args[2:])
+			return
+		}
+	}
 	log.Println("Starting riw server")
 	flag.Parse()
+	if boosts != "" {
+		if err := parseBoosts(boosts); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if staticScore != "" && !isStaticScore(staticScore) {
+		log.Fatalf("unknown static score %s", staticScore)
+	}
+	if err := parseCorpora(corporaList); err != nil {
+		log.Fatal(err)
+	}
+	if coordinatorShards != "" {
+		serveCoordinator(NewCoordinator(strings.Split(coordinatorShards, ",")))
+		return
+	}
+	// riw listens while the indexes are built, to report their progress
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	http.HandleFunc("/admin/progress", progressHandler)
+	listening := make(chan error)
+	go func() {
+		listening <- http.Serve(listener, nil)
+	}()
+	// an interrupt stops the builds, nothing is written for the indexes not built yet
+	ctx, cancel := context.WithCancel(context.Background())
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-interrupt
+		log.Println("Interrupted, stopping the index builds")
+		cancel()
+	}()
+
 	c := make(chan *Search)
 	// Build a set of common words
 	commonWord, err := os.Open(commonWordFile)
@@ -44,28 +244,47 @@ func main() {
 		cw[scanner.Text()] = true
 	}
 
-	go buildCACM(c, cw)
-	go buildCS276(c, cw)
-	var cacm, cs276 *Search
+	for _, corpus := range corpora {
+		go buildCorpus(ctx, c, corpus, cw)
+	}
+	// searches are served in the order of the -corpora flag
+	searches := make([]*Search, len(corpora))
 	var precall *PreCallCalculator
-	var s *Search
-	for i := 0; i < 2; i++ {
-		s = <-c
-		if s.Corpus == "cacm" {
-			cacm = s
-			if buildPrecall {
-				precall = NewPreCallCalculator()
-				precall.Populate("data/CACM/query.text", "data/CACM/qrels.text")
-				precall.Draw(cacm)
-				precall.Serialize()
-			} else {
-				precall = UnserializePreCallCalculator()
+	for range corpora {
+		s := <-c
+		if s == nil {
+			continue
+		}
+		for i, corpus := range corpora {
+			if corpus.Name() == s.Corpus {
+				searches[i] = s
 			}
+		}
+		tc, ok := s.corpus.(testCollection)
+		if !ok || precall != nil {
+			continue
+		}
+		topics, qrels := tc.Judgments()
+		if topics == "" {
+			continue
+		}
+		if buildPrecall {
+			precall = NewPreCallCalculator()
+			precall.Populate(s, topics, qrels)
+			precall.Draw(s)
+			precall.WriteRuns(s)
+			precall.Serialize(s.Corpus)
 		} else {
-			cs276 = s
+			precall = UnserializePreCallCalculator(s.Corpus)
 		}
 	}
-	serve(cacm, cs276, precall)
+	if ctx.Err() != nil {
+		log.Fatal("riw interrupted")
+	}
+	// once built, an interrupt stops riw
+	signal.Stop(interrupt)
+	serve(searches, precall)
+	log.Fatal(<-listening)
 }
 
 // draw generates heaps law graph
@@ -107,38 +326,35 @@ func draw(search *Search) {
 	}
 }
 
-func buildCACM(c chan *Search, cw map[string]bool) {
-	var cacm *Search
+// buildCorpus builds the index of a corpus, or loads it, then applies the index updates
+// it sends nil if ctx is cancelled meanwhile, an index interrupted isn't written
+func buildCorpus(ctx context.Context, c chan *Search, corpus Corpus, cw map[string]bool) {
+	var search *Search
+	name := corpus.Name()
 	if buildIndex {
-		log.Println("Building cacm index from scratch")
-		source, err := os.Open(cacmFile)
-		if err != nil {
-			panic(err)
+		log.Printf("Building %s index from scratch\n", name)
+		search = ParseCorpus(ctx, corpus, cw)
+		if ctx.Err() != nil {
+			log.Printf("%s index build interrupted, nothing written\n", name)
+			c <- nil
+			return
 		}
-		defer source.Close()
-		cacm = ParseCACM(source, cw)
-		source.Close()
-		draw(cacm)
-		cacm.Serialize()
+		draw(search)
+		keepShard(search)
+		search.Serialize()
 	} else {
-		log.Println("Loading cacm index from file")
-		cacm = UnserializeSearch("cacm")
-		cacm.toUrl = cacmToUrl
+		log.Printf("Loading %s index from file\n", name)
+		var err error
+		search, err = UnserializeSearch(name)
+		if err != nil {
+			log.Fatalln(err)
+		}
 	}
-	c <- cacm
-}
-
-func buildCS276(c chan *Search, cw map[string]bool) {
-	var cs276 *Search
-	if buildIndex {
-		log.Println("Building cs276 index from scratch")
-		cs276 = ParseCS276(cs276File, cw)
-		draw(cs276)
-		cs276.Serialize()
-	} else {
-		log.Println("Loading cs276 index from file")
-		cs276 = UnserializeSearch("cs276")
-		cs276.toUrl = cs276ToUrl
+	updateIndex(ctx, search)
+	if ctx.Err() != nil {
+		c <- nil
+		return
 	}
-	c <- cs276
+	search.StartMerger()
+	c <- search
 }
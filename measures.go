@@ -0,0 +1,329 @@
+// Measures.go implements the evaluation measures of a ranked list of documents
+// they follow the definitions used by trec_eval
+// judgments map a document id to its relevance grade, a grade of 0 is a judged non relevant document
+// documents absent from the judgments are unjudged, they are considered non relevant
+// except for bpref which ignores them
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// ndcgDepth is the rank at which nDCG is cut
+const ndcgDepth = 10
+
+// Measures are the evaluation measures of one ranked list
+type Measures struct {
+	// AP is the average precision, its mean over queries is the MAP
+	AP float64
+	// P5, P10 and P20 are the precision at rank 5, 10 and 20
+	P5, P10, P20 float64
+	// RPrec is the precision at rank R, the number of relevant documents
+	RPrec float64
+	// RR is the reciprocal rank of the first relevant document
+	RR float64
+	// NDCG is the normalized discounted cumulative gain at rank ndcgDepth
+	NDCG float64
+	// Bpref measures how many judged non relevant documents are ranked before relevant ones
+	Bpref float64
+	// Interpolated is the interpolated precision at recall 0, 0.1, ... 1
+	Interpolated [11]float64
+}
+
+// refIds returns the ids of a ranked list of refs
+func refIds(refs []Ref) []int {
+	ids := make([]int, len(refs))
+	for i, ref := range refs {
+		ids[i] = ref.Id
+	}
+	return ids
+}
+
+// evaluate computes all measures of a ranked list of documents
+func evaluate(ranked []int, judged map[int]int) Measures {
+	return Measures{
+		AP:           averagePrecision(ranked, judged),
+		P5:           precisionAt(ranked, judged, 5),
+		P10:          precisionAt(ranked, judged, 10),
+		P20:          precisionAt(ranked, judged, 20),
+		RPrec:        precisionAt(ranked, judged, relevantCount(judged)),
+		RR:           reciprocalRank(ranked, judged),
+		NDCG:         ndcg(ranked, judged, ndcgDepth),
+		Bpref:        bpref(ranked, judged),
+		Interpolated: interpolatedPrecision(ranked, judged),
+	}
+}
+
+// SetMeasures are the evaluation measures of an unranked set of documents
+type SetMeasures struct {
+	// Retrieved is the number of documents in the set
+	Retrieved float64
+	Precision float64
+	Recall    float64
+	// F1 is the harmonic mean of precision and recall
+	F1 float64
+}
+
+// evaluateSet computes the measures of a set of documents, as returned by boolean queries
+func evaluateSet(retrieved []int, judged map[int]int) SetMeasures {
+	m := SetMeasures{Retrieved: float64(len(retrieved))}
+	r := relevantCount(judged)
+	if len(retrieved) == 0 || r == 0 {
+		return m
+	}
+	var found int
+	for _, id := range retrieved {
+		if judged[id] > 0 {
+			found++
+		}
+	}
+	m.Precision = float64(found) / float64(len(retrieved))
+	m.Recall = float64(found) / float64(r)
+	if found > 0 {
+		m.F1 = 2 * m.Precision * m.Recall / (m.Precision + m.Recall)
+	}
+	return m
+}
+
+// meanSetMeasures returns the mean of each set measure
+func meanSetMeasures(all []SetMeasures) SetMeasures {
+	var mean SetMeasures
+	if len(all) == 0 {
+		return mean
+	}
+	for _, m := range all {
+		mean.Retrieved += m.Retrieved
+		mean.Precision += m.Precision
+		mean.Recall += m.Recall
+		mean.F1 += m.F1
+	}
+	n := float64(len(all))
+	mean.Retrieved /= n
+	mean.Precision /= n
+	mean.Recall /= n
+	mean.F1 /= n
+	return mean
+}
+
+// relevantCount returns the number of relevant documents
+func relevantCount(judged map[int]int) int {
+	var count int
+	for _, grade := range judged {
+		if grade > 0 {
+			count++
+		}
+	}
+	return count
+}
+
+// averagePrecision is the mean of the precision at the rank of each relevant document
+// relevant documents not retrieved count as a precision of 0
+func averagePrecision(ranked []int, judged map[int]int) float64 {
+	r := relevantCount(judged)
+	if r == 0 {
+		return 0
+	}
+	var found int
+	var sum float64
+	for i, id := range ranked {
+		if judged[id] > 0 {
+			found++
+			sum += float64(found) / float64(i+1)
+		}
+	}
+	return sum / float64(r)
+}
+
+// precisionAt returns the fraction of relevant documents in the k first ones
+// missing documents when less than k are retrieved count as non relevant
+func precisionAt(ranked []int, judged map[int]int, k int) float64 {
+	if k == 0 {
+		return 0
+	}
+	var found int
+	for i, id := range ranked {
+		if i == k {
+			break
+		}
+		if judged[id] > 0 {
+			found++
+		}
+	}
+	return float64(found) / float64(k)
+}
+
+// reciprocalRank returns 1 over the rank of the first relevant document
+func reciprocalRank(ranked []int, judged map[int]int) float64 {
+	for i, id := range ranked {
+		if judged[id] > 0 {
+			return 1 / float64(i+1)
+		}
+	}
+	return 0
+}
+
+// gain is the gain of a document of a relevance grade for nDCG
+// the grade itself as in trec_eval, not the exponential 2^grade-1
+func gain(grade int) float64 {
+	return float64(grade)
+}
+
+// ndcg returns the discounted cumulative gain at rank k
+// normalized by the one of the ideal ranking
+func ndcg(ranked []int, judged map[int]int, k int) float64 {
+	var dcg float64
+	for i, id := range ranked {
+		if i == k {
+			break
+		}
+		if grade := judged[id]; grade > 0 {
+			dcg += gain(grade) / math.Log2(float64(i+2))
+		}
+	}
+
+	grades := make([]int, 0, len(judged))
+	for _, grade := range judged {
+		if grade > 0 {
+			grades = append(grades, grade)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(grades)))
+	var ideal float64
+	for i, grade := range grades {
+		if i == k {
+			break
+		}
+		ideal += gain(grade) / math.Log2(float64(i+2))
+	}
+	if ideal == 0 {
+		return 0
+	}
+	return dcg / ideal
+}
+
+// bpref returns the binary preference measure, only judged documents are considered
+// each relevant document retrieved is penalized by the judged non relevant documents ranked above it
+func bpref(ranked []int, judged map[int]int) float64 {
+	r := relevantCount(judged)
+	if r == 0 {
+		return 0
+	}
+	n := len(judged) - r
+	bound := r
+	if n < bound {
+		bound = n
+	}
+	var nonRelevant int
+	var sum float64
+	for _, id := range ranked {
+		grade, ok := judged[id]
+		switch {
+		case !ok:
+			// unjudged documents are ignored
+		case grade == 0:
+			nonRelevant++
+		case bound == 0:
+			sum++
+		default:
+			above := nonRelevant
+			if above > r {
+				above = r
+			}
+			sum += 1 - float64(above)/float64(bound)
+		}
+	}
+	return sum / float64(r)
+}
+
+// interpolatedPrecision returns the precision at the 11 standard recall levels
+// the interpolated precision at recall x is the highest precision for a recall >= x
+func interpolatedPrecision(ranked []int, judged map[int]int) [11]float64 {
+	var levels [11]float64
+	r := relevantCount(judged)
+	if r == 0 {
+		return levels
+	}
+	var found int
+	for i, id := range ranked {
+		if judged[id] == 0 {
+			continue
+		}
+		found++
+		precision := float64(found) / float64(i+1)
+		recall := float64(found) / float64(r)
+		for l := range levels {
+			// small epsilon as 0.1 * 3 isn't exactly 0.3
+			if float64(l)/10 <= recall+1e-9 && precision > levels[l] {
+				levels[l] = precision
+			}
+		}
+	}
+	return levels
+}
+
+// meanMeasures returns the mean of each measure
+func meanMeasures(all []Measures) Measures {
+	var mean Measures
+	if len(all) == 0 {
+		return mean
+	}
+	for _, m := range all {
+		mean.AP += m.AP
+		mean.P5 += m.P5
+		mean.P10 += m.P10
+		mean.P20 += m.P20
+		mean.RPrec += m.RPrec
+		mean.RR += m.RR
+		mean.NDCG += m.NDCG
+		mean.Bpref += m.Bpref
+		for l := range mean.Interpolated {
+			mean.Interpolated[l] += m.Interpolated[l]
+		}
+	}
+	n := float64(len(all))
+	mean.AP /= n
+	mean.P5 /= n
+	mean.P10 /= n
+	mean.P20 /= n
+	mean.RPrec /= n
+	mean.RR /= n
+	mean.NDCG /= n
+	mean.Bpref /= n
+	for l := range mean.Interpolated {
+		mean.Interpolated[l] /= n
+	}
+	return mean
+}
+
+// measureNames are the names of the measures, as used by trec_eval
+var measureNames = []string{"map", "P_5", "P_10", "P_20", "Rprec", "recip_rank", "ndcg_cut_10", "bpref"}
+
+// value returns a measure from its name, or false if there is no such measure
+func (m Measures) value(name string) (float64, bool) {
+	values := []float64{m.AP, m.P5, m.P10, m.P20, m.RPrec, m.RR, m.NDCG, m.Bpref}
+	for i, n := range measureNames {
+		if n == name {
+			return values[i], true
+		}
+	}
+	return 0, false
+}
+
+// measuresHeader is the header of the tsv table of measures
+const measuresHeader = "map\tP_5\tP_10\tP_20\tRprec\trecip_rank\tndcg_cut_10\tbpref" +
+	"\tiprec_at_recall_0.00\tiprec_at_recall_0.10\tiprec_at_recall_0.20\tiprec_at_recall_0.30" +
+	"\tiprec_at_recall_0.40\tiprec_at_recall_0.50\tiprec_at_recall_0.60\tiprec_at_recall_0.70" +
+	"\tiprec_at_recall_0.80\tiprec_at_recall_0.90\tiprec_at_recall_1.00"
+
+// writeTSV writes the measures as a line of tsv, after the given prefix columns
+func (m Measures) writeTSV(w io.Writer, prefix string) {
+	fmt.Fprintf(w, "%s\t%.4f\t%.4f\t%.4f\t%.4f\t%.4f\t%.4f\t%.4f\t%.4f",
+		prefix, m.AP, m.P5, m.P10, m.P20, m.RPrec, m.RR, m.NDCG, m.Bpref)
+	for _, p := range m.Interpolated {
+		fmt.Fprintf(w, "\t%.4f", p)
+	}
+	fmt.Fprintln(w)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMeasures(t *testing.T) {
+	// relevant documents are 1, 3 and 7, 5 is judged non relevant, 7 isn't retrieved
+	judged := map[int]int{1: 1, 3: 1, 5: 0, 7: 1}
+	ranked := []int{2, 1, 5, 3, 4}
+	m := evaluate(ranked, judged)
+
+	expected := Measures{
+		AP:    (1.0/2 + 2.0/4) / 3,
+		P5:    2.0 / 5,
+		P10:   2.0 / 10,
+		P20:   2.0 / 20,
+		RPrec: 1.0 / 3,
+		RR:    1.0 / 2,
+		NDCG:  (1/math.Log2(3) + 1/math.Log2(5)) / (1 + 1/math.Log2(3) + 1/math.Log2(4)),
+		// 3 is ranked after one of the judged non relevant documents
+		Bpref:        (1 + 0) / 3.0,
+		Interpolated: [11]float64{0.5, 0.5, 0.5, 0.5, 0.5, 0.5, 0.5, 0, 0, 0, 0},
+	}
+	got := []float64{m.AP, m.P5, m.P10, m.P20, m.RPrec, m.RR, m.NDCG, m.Bpref}
+	want := []float64{expected.AP, expected.P5, expected.P10, expected.P20,
+		expected.RPrec, expected.RR, expected.NDCG, expected.Bpref}
+	names := []string{"AP", "P5", "P10", "P20", "RPrec", "RR", "NDCG", "Bpref"}
+	for i := range got {
+		if math.Abs(got[i]-want[i]) > 1e-9 {
+			t.Errorf("%s is %f, expected %f", names[i], got[i], want[i])
+		}
+	}
+	for l := range m.Interpolated {
+		if math.Abs(m.Interpolated[l]-expected.Interpolated[l]) > 1e-9 {
+			t.Errorf("Interpolated precision at %d0%% is %f, expected %f",
+				l, m.Interpolated[l], expected.Interpolated[l])
+		}
+	}
+
+	mean := meanMeasures([]Measures{m, evaluate(nil, judged)})
+	if math.Abs(mean.AP-expected.AP/2) > 1e-9 {
+		t.Errorf("MAP is %f, expected %f", mean.AP, expected.AP/2)
+	}
+}
+
+func TestGradedNDCG(t *testing.T) {
+	// trec_eval takes the grade as gain: the dcg is 1/log2(2) + 2/log2(4) = 2,
+	// the ideal one 3/log2(2) + 2/log2(3) + 1/log2(4), ndcg_cut_10 is 0.4200
+	judged := map[int]int{1: 2, 2: 1, 3: 0, 4: 3}
+	if n := ndcg([]int{2, 3, 1, 5}, judged, ndcgDepth); math.Abs(n-0.4200) > 5e-5 {
+		t.Errorf("NDCG of graded judgments is %f, expected 0.4200", n)
+	}
+}
+
+func TestSignificance(t *testing.T) {
+	a := []float64{1, 2, 3, 4, 5}
+	b := []float64{0, 0, 0, 0, 0}
+	// t = 4.2426 with 4 degrees of freedom
+	if p := pairedTTest(a, b); math.Abs(p-0.013236) > 1e-5 {
+		t.Errorf("t-test p-value is %f, expected 0.013236", p)
+	}
+	if p := pairedTTest(a, a); p != 1 {
+		t.Errorf("t-test p-value of identical values is %f, expected 1", p)
+	}
+	// all 32 sign assignments are equally likely, only 2 are as extreme as the observed one
+	if p := randomizationTest(a, b, 100000); math.Abs(p-2.0/32) > 0.005 {
+		t.Errorf("randomization p-value is %f, expected %f", p, 2.0/32)
+	}
+}
@@ -3,6 +3,7 @@ package main
 
 import (
 	"os"
+	"runtime"
 	"time"
 )
 
@@ -11,10 +12,13 @@ type Perf struct {
 	// Parsing is the time taken to parse all documents
 	// build the temporary index and add metadata
 	Parsing time.Duration
-	// IDF is the time taken to build the IDf
-	IDF time.Duration
-	// Indexing is the time taken to build the trie
+	// Indexing is the time taken to merge the private indexes of the workers, or the runs written to disk, in the final index
+	// 0 when the index was built in memory, see spimi.go
 	Indexing time.Duration
+	// Runs is the number of runs written when the memory was bounded by -memory
+	Runs int
+	// Memory is the peak of the heap in use while building the index, sampled
+	Memory uint64
 	// Serialization is the time taken to serialize the whole Search struct
 	// excluding the Perf obviously
 	Serialization time.Duration
@@ -22,8 +26,10 @@ type Perf struct {
 	TotalTime time.Duration
 	// Index is the size of the docsID list
 	Index uint64
-	// Title the size of the list of titles
+	// Title the size of the list of titles and external ids
 	Titles uint64
+	// Docs is the size of the document store
+	Docs uint64
 	// Total size of the indexes
 	TotalSize uint64
 	// Initial size of the corpus
@@ -44,18 +50,52 @@ func newCS276Perf() Perf {
 
 // getFinalValues complete the perf object
 func (p Perf) getFinalValues() Perf {
-	index, err := os.Lstat("indexes/" + p.Name + ".index")
+	index, err := os.Lstat(indexFile(p.Name + ".index"))
 	if err != nil {
 		panic(err)
 	}
 	p.Index = uint64(index.Size())
-	titles, err := os.Lstat("indexes/" + p.Name + ".titles")
+	titles, err := os.Lstat(indexFile(p.Name + ".titles"))
 	if err != nil {
 		panic(err)
 	}
 	p.Titles = uint64(titles.Size())
-	p.TotalSize = p.Index + p.Titles
-	p.TotalTime = p.Parsing + p.IDF + p.Indexing + p.Serialization
+	if ids, err := os.Lstat(indexFile(p.Name + ".ids")); err == nil {
+		p.Titles += uint64(ids.Size())
+	}
+	if docs, err := os.Lstat(indexFile(p.Name + ".docs")); err == nil {
+		p.Docs = uint64(docs.Size())
+	}
+	p.TotalSize = p.Index + p.Titles + p.Docs
+	p.TotalTime = p.Parsing + p.Indexing + p.Serialization
 	p.Ratio = float64(p.TotalSize) / float64(p.Initial)
 	return p
 }
+
+// sampleMemory samples the heap in use until the returned function is called, it returns the peak
+func sampleMemory() func() uint64 {
+	done := make(chan bool)
+	peak := make(chan uint64)
+	go func() {
+		var m runtime.MemStats
+		var max uint64
+		ticker := time.NewTicker(50 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			runtime.ReadMemStats(&m)
+			if m.HeapInuse > max {
+				max = m.HeapInuse
+			}
+			select {
+			case <-ticker.C:
+			case <-done:
+				peak <- max
+				return
+			}
+		}
+	}()
+	return func() uint64 {
+		done <- true
+		return <-peak
+	}
+}
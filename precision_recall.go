@@ -1,10 +1,9 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
 	"encoding/gob"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path"
@@ -19,18 +18,39 @@ import (
 
 // PreCallCalculator is the struct that caluclate Precision and Recall from queries and answer
 type PreCallCalculator struct {
-	// Queries is a list of string corresponding to queries
-	Queries []string
-	// Answer is a list list fo valid doc ID for each query
-	Answer [][]int
-	// Valid is a list of valid graphs (i.e not empty)
-	Valids []int
-	// MAP is the Mean Average Precision Value
-	MAP [total]float64
+	// Corpus is the name of the corpus evaluated
+	Corpus string
+	// Topics is the list of queries
+	Topics []Topic
+	// Judgments are the relevance grades of the judged documents of each topic, by doc ID
+	Judgments []map[int]int
+	// Evaluation holds the measures of each query having relevant documents
+	Evaluation []QueryEvaluation
+	// Mean is the mean of the measures over the evaluated queries, Mean.AP is the MAP
+	Mean [total]Measures
+	// Area is the area under the average precision/recall graph
+	Area [total]float64
+	// BooleanMean is the mean of the boolean queries measures
+	BooleanMean SetMeasures
 	// descirption of the differentts weight function
 	Descrpt [total]string
 }
 
+// QueryEvaluation holds the measures of a query for each weight function
+type QueryEvaluation struct {
+	// Query is the index of the topic, Id its identifier and Text the query itself
+	Query   int
+	Id      string
+	Text    string
+	Authors []string
+	// Graph is true if the precision/recall graph has been drawn
+	Graph    bool
+	Measures [total]Measures
+	// BooleanQuery is the boolean version of the query and Boolean its measures
+	BooleanQuery string
+	Boolean      SetMeasures
+}
+
 // Point is a value in a precision/recall graph
 type Point struct {
 	X, Y float64
@@ -41,57 +61,20 @@ func NewPreCallCalculator() *PreCallCalculator {
 	return &PreCallCalculator{Descrpt: weightName}
 }
 
-// Populate adds all the needed values to tue queries and answer list
-func (p *PreCallCalculator) Populate(query string, answer string) {
-	// Counter when population the array
-	var qid, aid int
-	// Indicates when we are in a query bloc
-	var inQBloc bool
-	Q, err := os.Open(query)
-	if err != nil {
-		panic(err.Error())
-	}
-	scanner := bufio.NewScanner(Q)
-	var buf bytes.Buffer
-	var ln []byte
-	for scanner.Scan() {
-		ln = scanner.Bytes()
-		switch {
-		case bytes.HasPrefix(ln, []byte(".I")):
-			if qid > 0 {
-				p.Queries = append(p.Queries, buf.String())
-				buf.Reset()
-			}
-			qid++
-		case bytes.HasPrefix(ln, []byte(".W")):
-			inQBloc = true
-		// Seems to suffice for all block indicator
-		case bytes.HasPrefix(ln, []byte(".")):
-			inQBloc = false
-		case inQBloc:
-			buf.Write(ln)
-			buf.Write([]byte(" "))
-		}
-	}
-	p.Queries = append(p.Queries, buf.String())
-	A, err := os.Open(answer)
-	if err != nil {
-		panic(err.Error())
-	}
-	scanner = bufio.NewScanner(A)
-	var line string
-	var ans, length int
-	for scanner.Scan() {
-		line = scanner.Text()
-		fmt.Sscanf(line, "%d %d", &aid, &ans)
-		length = len(p.Answer)
-		if aid > length {
-			//append a new empty slice
-			p.Answer = append(p.Answer, []int{})
-			length++
+// Populate reads the topics and their relevance judgments
+// the judged documents numbers are resolved to the ids of search
+func (p *PreCallCalculator) Populate(search *Search, topics string, qrels string) {
+	p.Corpus = search.Corpus
+	p.Topics = ReadTopics(topics)
+	addBooleanTopics(p.Topics, booleanFile(topics), search.CW)
+	judgments := ReadQrels(qrels)
+	ids := search.docIDs()
+	p.Judgments = make([]map[int]int, len(p.Topics))
+	for i, topic := range p.Topics {
+		// topics without judgments can't be evaluated, they stay nil
+		if judged, ok := judgments[topic.Id]; ok {
+			p.Judgments[i] = resolveJudgments(judged, ids)
 		}
-		// Substract 1 from ans to compensate search starting from 0
-		p.Answer[length-1] = append(p.Answer[length-1], ans-1)
 	}
 }
 
@@ -114,20 +97,26 @@ func (p *PreCallCalculator) Draw(cacm *Search) {
 		panic(err)
 	}
 
-	valids := make([]int, len(p.Queries))
-	// small hack, valids[i] == i if the graph for query i is valid
-	// array are initialised with 0, so valids[0] must be != 0
-	valids[0] = -1
+	evaluations := make([]QueryEvaluation, len(p.Topics))
 
 	// Store all plots used,
 	// It is used to get averages
 	var Average [total][]*plotter.Function
 	for wf := 0; wf < total; wf++ {
-		Average[wf] = make([]*plotter.Function, len(p.Queries))
+		Average[wf] = make([]*plotter.Function, len(p.Topics))
 	}
 
-	for i := range p.Queries {
-		go func(i int) {
+	for i, topic := range p.Topics {
+		go func(i int, topic Topic) {
+			judged := p.Judgments[i]
+			if relevantCount(judged) == 0 {
+				// no relevant documents, the query can't be evaluated
+				sem <- true
+				return
+			}
+			evaluations[i] = QueryEvaluation{Query: i, Id: topic.Id, Text: topic.Text, Authors: topic.Authors, BooleanQuery: topic.Boolean}
+			// boolean results aren't ordered, only set measures make sense
+			evaluations[i].Boolean = evaluateSet(refIds(BooleanQuery(cacm, topic.Boolean)), judged)
 			file := path.Join(dir, strconv.Itoa(i)+".svg")
 			plt := getPlot()
 
@@ -136,14 +125,15 @@ func (p *PreCallCalculator) Draw(cacm *Search) {
 			var useful bool
 			// iterate over all weight function in parrallel
 			for wf := 0; wf < total; wf++ {
-				refs := VectorQuery(cacm, p.Queries[i], weight(wf))
+				refs := TopicQuery(cacm, topic, weight(wf))
+				evaluations[i].Measures[wf] = evaluate(refIds(refs), judged)
 
 				// Number of effectively valid answer
 				var effective int
-				valid := float64(len(p.Answer[i]))
+				valid := float64(relevantCount(judged))
 				pts := make(plotter.XYs, 0)
 				for count, ref := range refs {
-					if contains(p.Answer[i], ref.Id) {
+					if judged[ref.Id] > 0 {
 						effective++
 						// Adds a point with X = recall, Y = precision
 						pts = append(pts,
@@ -169,30 +159,36 @@ func (p *PreCallCalculator) Draw(cacm *Search) {
 				sem <- true
 				return
 			}
-			valids[i] = i
+			evaluations[i].Graph = true
 
 			if err = plt.Save(20*vg.Centimeter, 20*vg.Centimeter, file); err != nil {
 				panic(err)
 			}
 			sem <- true
-		}(i)
+		}(i, topic)
 	}
 
 	// Wait for all graphs to be generated
-	for i := 0; i < len(p.Queries); i++ {
+	for range p.Topics {
 		<-sem
 	}
 
-	var count int
-	newQ := make([]string, 0, len(p.Queries))
-	for i, val := range valids {
-		if i == val {
-			count++
-			p.Valids = append(p.Valids, val)
-			newQ = append(newQ, p.Queries[i])
+	var measures [total][]Measures
+	var booleans []SetMeasures
+	for i, ev := range evaluations {
+		if relevantCount(p.Judgments[i]) == 0 {
+			continue
+		}
+		p.Evaluation = append(p.Evaluation, ev)
+		booleans = append(booleans, ev.Boolean)
+		for wf := 0; wf < total; wf++ {
+			measures[wf] = append(measures[wf], ev.Measures[wf])
 		}
 	}
-	p.Queries = newQ
+	for wf := 0; wf < total; wf++ {
+		p.Mean[wf] = meanMeasures(measures[wf])
+	}
+	p.BooleanMean = meanSetMeasures(booleans)
 
 	// Graph for the averages
 	file := path.Join(dir, "avg.svg")
@@ -205,7 +201,7 @@ func (p *PreCallCalculator) Draw(cacm *Search) {
 		plt.Add(f[wf])
 		wn := weightName[wf]
 		plt.Legend.Add(wn, f[wf])
-		p.MAP[wf] = getMAP(f[wf])
+		p.Area[wf] = getArea(f[wf])
 	}
 	if err = plt.Save(20*vg.Centimeter, 20*vg.Centimeter, file); err != nil {
 		panic(err)
@@ -215,9 +211,9 @@ func (p *PreCallCalculator) Draw(cacm *Search) {
 	log.Printf("Precision recall graph generated in %s", time.Since(now).String())
 }
 
-// Serialize saves to file the data in PreCallCalculator
-func (p *PreCallCalculator) Serialize() {
-	precall, err := os.Create(path.Join("indexes", "cacm.precall"))
+// Serialize saves to file the data in PreCallCalculator, next to the index of the corpus evaluated
+func (p *PreCallCalculator) Serialize(corpus string) {
+	precall, err := createIndexFile(corpus + ".precall")
 	if err != nil {
 		panic(err)
 	}
@@ -227,12 +223,57 @@ func (p *PreCallCalculator) Serialize() {
 	if err != nil {
 		panic(err)
 	}
+	precall.commit()
+}
+
+// WriteTSV writes the measures of all evaluated queries as a tsv table
+// the means of each weight function are on the lines of query "all"
+func (p *PreCallCalculator) WriteTSV(w io.Writer) {
+	fmt.Fprintf(w, "weight\tquery\t%s\n", measuresHeader)
+	for wf := 0; wf < total; wf++ {
+		for _, ev := range p.Evaluation {
+			ev.Measures[wf].writeTSV(w, weightName[wf]+"\t"+ev.Id)
+		}
+		p.Mean[wf].writeTSV(w, weightName[wf]+"\tall")
+	}
+}
+
+// runFile returns the name of the TREC run of a weight function, in graphs/precision_recall
+func (p *PreCallCalculator) runFile(wf weight) string {
+	return fmt.Sprintf("%s.%s.run", p.Corpus, weightParam[wf])
+}
+
+// RunLink is the link to the TREC run of a weight function
+type RunLink struct {
+	Weight string
+	Url    string
+}
+
+// Runs returns the links to the TREC runs written by WriteRuns
+func (p *PreCallCalculator) Runs() []RunLink {
+	links := make([]RunLink, total)
+	for wf := range links {
+		links[wf] = RunLink{weightParam[wf], "/" + path.Join(graphs, "precision_recall", p.runFile(weight(wf)))}
+	}
+	return links
+}
+
+// WriteRuns writes a TREC run of each weight function in the graphs folder
+func (p *PreCallCalculator) WriteRuns(search *Search) {
+	for wf := 0; wf < total; wf++ {
+		run, err := os.Create(path.Join(graphs, "precision_recall", p.runFile(weight(wf))))
+		if err != nil {
+			panic(err)
+		}
+		WriteRun(run, search, p.Topics, weight(wf), "riw-"+weightParam[wf])
+		run.Close()
+	}
 }
 
 // UnserializePreCallCalculator loads a serializes PeCallCalculator
-func UnserializePreCallCalculator() *PreCallCalculator {
+func UnserializePreCallCalculator(corpus string) *PreCallCalculator {
 	var p *PreCallCalculator
-	precall, err := os.Open(path.Join("indexes", "cacm.precall"))
+	precall, err := os.Open(indexFile(corpus + ".precall"))
 	if err != nil {
 		panic(err)
 	}
@@ -257,15 +298,6 @@ func getPlot() *plot.Plot {
 	return p
 }
 
-func contains(haystack []int, needle int) bool {
-	for _, hay := range haystack {
-		if hay == needle {
-			return true
-		}
-	}
-	return false
-}
-
 // funcFromPoints generate a function for the plotter interface
 // the function respects precision recall graph logic
 func funcFromPoints(pts plotter.XYs) *plotter.Function {
@@ -306,7 +338,8 @@ func averageFunction(funcs []*plotter.Function) *plotter.Function {
 	return f
 }
 
-func getMAP(f *plotter.Function) float64 {
+// getArea returns the area under a precision/recall graph
+func getArea(f *plotter.Function) float64 {
 	sample := 256
 	var avg float64
 	for i := 0; i < sample; i++ {
@@ -0,0 +1,139 @@
+// Progress.go reports the progress of index builds, in the logs every progressPeriod and on /admin/progress
+// builds count the documents indexed and the bytes of their source read, the rate and the time left
+// are estimated from them, the total being the size of the source when it's known
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+)
+
+// progressPeriod is the time between two logs of the progress of a build
+const progressPeriod = 10 * time.Second
+
+// progress is the progress of the build of an index, or of a segment added to it
+type progress struct {
+	mu     sync.Mutex
+	corpus string
+	start  time.Time
+	docs   int
+	// read and total are the bytes of the source read and to read, total is 0 when unknown
+	read, total uint64
+	// state is scanning, merging once all documents are read, then done or interrupted
+	state string
+	stop  chan struct{}
+}
+
+// builds are the progress of the builds since riw started, shown on /admin/progress
+var builds struct {
+	sync.Mutex
+	list []*progress
+}
+
+// startProgress starts reporting the build of corpus, whose source is total bytes
+func startProgress(corpus string, total uint64) *progress {
+	p := &progress{corpus: corpus, start: time.Now(), total: total, state: "scanning", stop: make(chan struct{})}
+	builds.Lock()
+	builds.list = append(builds.list, p)
+	builds.Unlock()
+	go func() {
+		ticker := time.NewTicker(progressPeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				log.Println(p.report())
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+	return p
+}
+
+// add counts a document indexed
+func (p *progress) add(doc metadata) {
+	p.mu.Lock()
+	p.docs++
+	p.read += uint64(doc.read)
+	p.mu.Unlock()
+}
+
+func (p *progress) setState(state string) {
+	p.mu.Lock()
+	p.state = state
+	p.mu.Unlock()
+}
+
+// done ends the build, state is done or interrupted
+func (p *progress) done(state string) {
+	p.setState(state)
+	close(p.stop)
+	log.Println(p.report())
+}
+
+// progressReport is the progress of a build as served on /admin/progress
+type progressReport struct {
+	Corpus    string
+	State     string
+	Documents int
+	// Read and Total are in bytes, Total is 0 when the size of the source isn't known
+	Read  uint64
+	Total uint64
+	// Elapsed and Left are in seconds, Left is estimated from the bytes read and is 0 when unknown
+	Elapsed float64
+	Left    float64
+	// Rate is the number of documents indexed per second
+	Rate float64
+}
+
+// report returns the progress of the build so far
+func (p *progress) report() progressReport {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	r := progressReport{
+		Corpus:    p.corpus,
+		State:     p.state,
+		Documents: p.docs,
+		Read:      p.read,
+		Total:     p.total,
+		Elapsed:   time.Since(p.start).Seconds(),
+	}
+	if r.Elapsed > 0 {
+		r.Rate = float64(r.Documents) / r.Elapsed
+	}
+	if p.state == "scanning" && r.Read > 0 && r.Total > r.Read {
+		r.Left = r.Elapsed * float64(r.Total-r.Read) / float64(r.Read)
+	}
+	return r
+}
+
+func (r progressReport) String() string {
+	read := humanize.Bytes(r.Read)
+	if r.Total > 0 {
+		read += " of " + humanize.Bytes(r.Total)
+	}
+	s := fmt.Sprintf("%s %s: %d documents, %s read, %.0f documents/s", r.Corpus, r.State, r.Documents, read, r.Rate)
+	if r.Left > 0 {
+		s += ", " + (time.Duration(r.Left) * time.Second).String() + " left"
+	}
+	return s
+}
+
+// progressHandler serves the progress of the builds as json
+func progressHandler(w http.ResponseWriter, r *http.Request) {
+	builds.Lock()
+	reports := make([]progressReport, len(builds.list))
+	for i, p := range builds.list {
+		reports[i] = p.report()
+	}
+	builds.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reports)
+}
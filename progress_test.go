@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProgress(t *testing.T) {
+	useTempIndexDir(t)
+	dir := writeTestCS276(t)
+	defer os.RemoveAll(dir)
+	corpus, _ := makeCorpus("testprogress", "cs276", dir)
+	ParseCorpus(context.Background(), corpus, map[string]bool{})
+	builds.Lock()
+	p := builds.list[len(builds.list)-1]
+	builds.Unlock()
+	r := p.report()
+	if r.Corpus != "testprogress" || r.State != "done" || r.Documents != 120 {
+		t.Fatalf("Incorrect progress: %+v", r)
+	}
+	if r.Read == 0 || r.Read != r.Total || r.Left != 0 {
+		t.Errorf("%d bytes read of %d, %f s left", r.Read, r.Total, r.Left)
+	}
+}
+
+func TestInterruptedBuild(t *testing.T) {
+	useTempIndexDir(t)
+	dir := writeTestCS276(t)
+	defer os.RemoveAll(dir)
+	corpus, _ := makeCorpus("testinterrupt", "cs276", dir)
+	trie := NewTrie()
+	trie.spillTo("testinterrupt", 300)
+	search := emptySearch("testinterrupt", map[string]bool{})
+	search.corpus = corpus
+	search.Segments = []*Segment{newSegment(search.Corpus, trie)}
+	// the build is interrupted once 30 documents are indexed
+	ctx, cancel := context.WithCancel(context.Background())
+	scanned, c := make(chan metadata), make(chan metadata)
+	go NewCS276Scanner(dir, trie).Scan(ctx, scanned)
+	go func() {
+		n := 0
+		for doc := range scanned {
+			if n++; n == 30 {
+				cancel()
+			}
+			c <- doc
+		}
+		close(c)
+	}()
+	p := startProgress(search.Corpus, 0)
+	buildSearchFromScanner(ctx, search, c, p)
+	if r := p.report(); r.State != "interrupted" || r.Documents < 30 || r.Documents == 120 {
+		t.Fatalf("Incorrect progress of the interrupted build: %+v", r)
+	}
+	if left, _ := filepath.Glob(indexFile("testinterrupt*")); len(left) > 0 {
+		t.Errorf("Files left: %v", left)
+	}
+
+	// a file not committed isn't written
+	w, err := createIndexFile("testinterrupt.index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("half"))
+	w.Close()
+	if left, _ := filepath.Glob(indexFile("testinterrupt*")); len(left) > 0 {
+		t.Errorf("Files left: %v", left)
+	}
+}
@@ -0,0 +1,434 @@
+// Records.go implements the corpora of records, JSON Lines or CSV files holding one document per line or row
+// the fields of a record are mapped to the fields of a document by the options of the source
+//
+//	-corpora docs=jsonl:data/docs.jsonl?title=name&body=text&body=summary&url=link&author=authors&keyword=tags&date=published
+//
+// id names the field identifying records, their external id, by default id, else the url or the position of the record
+// body, author and keyword can be repeated, date accepts "2006-01-02", "2006-01", "2006" and RFC 3339 dates
+// nested JSON fields are named with dots, "meta.title", arrays give one value per element
+// CSV files must start with a header naming their columns, comma=; or comma=tab changes the separator
+// the source can also be a folder, all its .jsonl (or .csv) files are read
+//
+// records are read by one goroutine and indexed by goroutineNumber workers, as CS276 files
+// the mapped document is kept as JSON in the document store, so pages and snippets don't need the mapping
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+func init() {
+	registerCorpus("jsonl", func(name, source string) (Corpus, error) {
+		return newRecordCorpus(name, "jsonl", source)
+	})
+	registerCorpus("csv", func(name, source string) (Corpus, error) {
+		return newRecordCorpus(name, "csv", source)
+	})
+}
+
+// recordMapping names the fields of the records holding each field of a document
+type recordMapping struct {
+	Id      string
+	Title   string
+	Url     string
+	Date    string
+	Body    []string
+	Author  []string
+	Keyword []string
+	// Comma is the separator of csv files
+	Comma rune
+}
+
+// parseRecordSource splits a source in its path and mapping options, "path?title=name&body=text"
+// fields default to id, title, body and url
+func parseRecordSource(source string) (string, recordMapping, error) {
+	m := recordMapping{Id: "id", Title: "title", Url: "url", Comma: ','}
+	path, options := source, ""
+	if i := strings.Index(source, "?"); i >= 0 {
+		path, options = source[:i], source[i+1:]
+	}
+	// url.ParseQuery would reject comma=;
+	values := make(map[string][]string)
+	for _, option := range strings.Split(options, "&") {
+		if option == "" {
+			continue
+		}
+		i := strings.Index(option, "=")
+		if i < 0 {
+			return "", m, fmt.Errorf("record option %q isn't written key=value", option)
+		}
+		value, err := url.QueryUnescape(option[i+1:])
+		if err != nil {
+			return "", m, err
+		}
+		values[option[:i]] = append(values[option[:i]], value)
+	}
+	for key, v := range values {
+		switch key {
+		case "id":
+			m.Id = v[len(v)-1]
+		case "title":
+			m.Title = v[len(v)-1]
+		case "url":
+			m.Url = v[len(v)-1]
+		case "date":
+			m.Date = v[len(v)-1]
+		case "body":
+			m.Body = v
+		case "author":
+			m.Author = v
+		case "keyword":
+			m.Keyword = v
+		case "comma":
+			sep := v[len(v)-1]
+			if sep == "tab" {
+				sep = "\t"
+			}
+			if utf8.RuneCountInString(sep) != 1 {
+				return "", m, fmt.Errorf("csv separator %q isn't a single character", sep)
+			}
+			m.Comma, _ = utf8.DecodeRuneInString(sep)
+		default:
+			return "", m, fmt.Errorf("unknown record option %s", key)
+		}
+	}
+	if m.Body == nil {
+		m.Body = []string{"body"}
+	}
+	return path, m, nil
+}
+
+// record is a document read from a record, as kept in the document store
+type record struct {
+	Id       string
+	Title    string
+	Url      string   `json:",omitempty"`
+	Body     string   `json:",omitempty"`
+	Authors  []string `json:",omitempty"`
+	Keywords []string `json:",omitempty"`
+	Date     int      `json:",omitempty"`
+}
+
+// document returns the document of the fields of a record
+// pos is the position of the record, used as id and title when it has none
+func (m recordMapping) document(fields map[string][]string, pos string) record {
+	r := record{
+		Id:    strings.Join(fields[m.Id], " "),
+		Title: strings.Join(fields[m.Title], " "),
+		Url:   strings.Join(fields[m.Url], " "),
+	}
+	var body []string
+	for _, f := range m.Body {
+		body = append(body, fields[f]...)
+	}
+	r.Body = strings.Join(body, "\n\n")
+	for _, f := range m.Author {
+		r.Authors = append(r.Authors, fields[f]...)
+	}
+	for _, f := range m.Keyword {
+		r.Keywords = append(r.Keywords, fields[f]...)
+	}
+	if m.Date != "" && len(fields[m.Date]) > 0 {
+		r.Date = parseDate(fields[m.Date][0])
+	}
+	if r.Title == "" {
+		r.Title = r.Url
+	}
+	if r.Title == "" {
+		r.Title = pos
+	}
+	if r.Id == "" {
+		r.Id = r.Url
+	}
+	if r.Id == "" {
+		r.Id = pos
+	}
+	return r
+}
+
+// dateLayouts are the layouts of the dates of records, tried in order
+var dateLayouts = []string{time.RFC3339, "2006-01-02", "2006-01", "January 2006", "January 2, 2006"}
+
+// parseDate returns a date of a record as stored by documentDate, 0 if it can't be parsed
+func parseDate(value string) int {
+	value = strings.TrimSpace(value)
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.Year()*100 + int(t.Month())
+		}
+	}
+	if yearTerm(value) != "" {
+		year, _ := strconv.Atoi(value)
+		return documentDate("", year)
+	}
+	return 0
+}
+
+// flattenJSON adds the values of a decoded JSON value to fields, under name
+// values of nested objects are named "name.key"
+func flattenJSON(fields map[string][]string, name string, value interface{}) {
+	switch v := value.(type) {
+	case string:
+		fields[name] = append(fields[name], v)
+	case json.Number:
+		// numbers are kept as written, ids above 2^53 would be rounded as float64
+		fields[name] = append(fields[name], v.String())
+	case bool:
+		fields[name] = append(fields[name], strconv.FormatBool(v))
+	case []interface{}:
+		for _, e := range v {
+			flattenJSON(fields, name, e)
+		}
+	case map[string]interface{}:
+		for key, e := range v {
+			if name != "" {
+				key = name + "." + key
+			}
+			flattenJSON(fields, key, e)
+		}
+	}
+}
+
+// recordCorpus is a corpus of JSON Lines or CSV records
+type recordCorpus struct {
+	name, source, format string
+	path                 string
+	mapping              recordMapping
+}
+
+func newRecordCorpus(name, format, source string) (Corpus, error) {
+	if source == "" {
+		return nil, fmt.Errorf("%s corpus %s has no source", format, name)
+	}
+	path, m, err := parseRecordSource(source)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return recordCorpus{name: name, source: source, format: format, path: path, mapping: m}, nil
+}
+
+func (c recordCorpus) Name() string   { return c.name }
+func (c recordCorpus) Kind() string   { return c.format }
+func (c recordCorpus) Source() string { return c.source }
+func (c recordCorpus) Perf() Perf     { return Perf{Initial: sourceSize(c.path)} }
+
+// Scanner returns a scanner of the records at source
+// sources of added documents without options use the mapping of the corpus
+func (c recordCorpus) Scanner(source string, cw map[string]bool, trie *Root) (Scanner, error) {
+	path, m := source, c.mapping
+	if strings.Contains(source, "?") {
+		var err error
+		if path, m, err = parseRecordSource(source); err != nil {
+			return nil, err
+		}
+	}
+	files, err := sourceFiles(path, "."+c.format)
+	if err != nil {
+		return nil, err
+	}
+	return &RecordScanner{
+		files:   files,
+		csv:     c.format == "csv",
+		mapping: m,
+		cw:      cw,
+		trie:    trie,
+		toScan:  make(chan rawRecord, 100),
+	}, nil
+}
+
+// Text returns the body of a stored record
+func (c recordCorpus) Text(stored []byte) string {
+	var r record
+	json.Unmarshal(stored, &r)
+	return r.Body
+}
+
+func (c recordCorpus) Analyze(word string) string { return stem(word) }
+
+// Page returns the body of the record, with a link to its url
+func (c recordCorpus) Page(s *Search, id int) (string, interface{}, error) {
+	stored, err := s.document(id)
+	if err != nil {
+		return "", nil, err
+	}
+	var r record
+	if err := json.Unmarshal(stored, &r); err != nil {
+		return "", nil, err
+	}
+	return "doc", textDoc{Title: r.Title, Source: r.Url, Text: r.Body}, nil
+}
+
+// sourceFiles returns the files at path, path itself or the files with the extension under it
+func sourceFiles(path, ext string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+	var files []string
+	err = filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && filepath.Ext(p) == ext {
+			files = append(files, p)
+		}
+		return err
+	})
+	return files, err
+}
+
+// rawRecord is a record as read from its file
+// JSON lines are decoded by the workers, CSV rows by the reader as rows can span lines
+type rawRecord struct {
+	line   []byte
+	fields map[string][]string
+	// pos is the file and line of the record, size its number of bytes
+	pos  string
+	size int
+}
+
+// RecordScanner indexes the records of JSON Lines or CSV files
+type RecordScanner struct {
+	files   []string
+	csv     bool
+	mapping recordMapping
+	cw      map[string]bool
+	trie    *Root
+	toScan  chan rawRecord
+}
+
+// Scan sends the scanned documents to the channel using multiple goroutines to index them
+func (s *RecordScanner) Scan(ctx context.Context, c chan metadata) {
+	// goroutine reading the records of the files in order
+	go func() {
+		for _, file := range s.files {
+			if ctx.Err() != nil {
+				break
+			}
+			if err := s.read(ctx, file); err != nil {
+				log.Println(err)
+			}
+		}
+		close(s.toScan)
+	}()
+	scanConcurrently(ctx, c, s.toScan, s.trie, s.decode)
+}
+
+// read sends the records of a file to be indexed, until ctx is cancelled
+func (s *RecordScanner) read(ctx context.Context, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if s.csv {
+		return s.readCSV(ctx, f, file)
+	}
+	r := bufio.NewReader(f)
+	for n := 1; ctx.Err() == nil; n++ {
+		line, err := r.ReadBytes('\n')
+		if len(strings.TrimSpace(string(line))) > 0 {
+			s.toScan <- rawRecord{line: line, pos: fmt.Sprintf("%s:%d", file, n), size: len(line)}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readCSV sends the rows of a csv file, named by its header
+func (s *RecordScanner) readCSV(ctx context.Context, f io.Reader, file string) error {
+	r := csv.NewReader(f)
+	r.Comma = s.mapping.Comma
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return fmt.Errorf("%s: %v", file, err)
+	}
+	offset := r.InputOffset()
+	for ctx.Err() == nil {
+		row, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if _, ok := err.(*csv.ParseError); ok {
+			// a malformed row is skipped, the reader goes on with the next line
+			log.Printf("%s: %v\n", file, err)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+		line, _ := r.FieldPos(0)
+		size := int(r.InputOffset() - offset)
+		offset = r.InputOffset()
+		fields := make(map[string][]string, len(header))
+		for i, value := range row {
+			if i < len(header) && value != "" {
+				fields[header[i]] = append(fields[header[i]], value)
+			}
+		}
+		s.toScan <- rawRecord{fields: fields, pos: fmt.Sprintf("%s:%d", file, line), size: size}
+	}
+	return nil
+}
+
+// decode reads a record in the document, JSON lines are decoded first
+func (s *RecordScanner) decode(doc *Document, raw rawRecord) error {
+	fields := raw.fields
+	if fields == nil {
+		var value interface{}
+		d := json.NewDecoder(bytes.NewReader(raw.line))
+		d.UseNumber()
+		if err := d.Decode(&value); err != nil {
+			return fmt.Errorf("%s: %v", raw.pos, err)
+		}
+		fields = make(map[string][]string)
+		flattenJSON(fields, "", value)
+	}
+	r := s.mapping.document(fields, raw.pos)
+	s.index(doc, r)
+	doc.Text, _ = json.Marshal(r)
+	doc.Read = raw.size
+	return nil
+}
+
+// index adds the words and field terms of a record to the document
+func (s *RecordScanner) index(doc *Document, r record) {
+	doc.Title = r.Title
+	doc.External = r.Id
+	doc.addText(r.Title, titlePrefix, s.cw)
+	doc.addText(r.Body, abstractPrefix, s.cw)
+	for _, k := range r.Keywords {
+		doc.addText(k, keywordPrefix, s.cw)
+	}
+	for _, a := range r.Authors {
+		if term := authorTerm(a); term != "" {
+			doc.addTerm(term)
+		}
+	}
+	if r.Date != 0 {
+		doc.Date = r.Date
+		if term := yearTerm(strconv.Itoa(r.Date / 100)); term != "" {
+			doc.addTerm(term)
+		}
+	}
+}
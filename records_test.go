@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecordCorpus(t *testing.T) {
+	useTempIndexDir(t)
+	dir, err := ioutil.TempDir("", "records")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	jsonl := filepath.Join(dir, "docs.jsonl")
+	ioutil.WriteFile(jsonl, []byte(`{"name": "Compiler construction", "text": "A compiler for an algebraic language", "meta": {"by": ["Knuth, D. E."], "year": 1968}}
+{"name": "Sorting records on tapes", "text": "Merging sorted tapes", "link": "http://example.com/tapes"}
+
+not json
+`), 0644)
+	csvFile := filepath.Join(dir, "docs.csv")
+	ioutil.WriteFile(csvFile, []byte("name;text;year\nCompiler construction;\"A compiler for an\nalgebraic language\";1968-05\nSorting records on tapes;Merging sorted tapes;\n"), 0644)
+
+	for _, source := range []string{
+		"jsonl:" + jsonl + "?title=name&body=text&url=link&author=meta.by&date=meta.year",
+		"csv:" + csvFile + "?title=name&body=text&date=year&comma=;",
+	} {
+		i := strings.Index(source, ":")
+		corpus, err := makeCorpus("test", source[:i], source[i+1:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		search := ParseCorpus(context.Background(), corpus, map[string]bool{})
+		if search.Size != 2 {
+			t.Fatalf("%s: %d documents indexed", source, search.Size)
+		}
+		refs := search.BooleanSearch("title:compiler AND year:1968")
+		if len(refs) != 1 || refs[0].Name != "Compiler construction" {
+			t.Errorf("%s: field query returned %v", source, refs)
+		}
+		refs = search.BooleanSearch("tapes")
+		if len(refs) != 1 {
+			t.Fatalf("%s: query returned %v", source, refs)
+		}
+		text, err := search.document(refs[0].Id)
+		if err != nil || corpus.Text(text) != "Merging sorted tapes" {
+			t.Errorf("%s: stored text %q, %v", source, text, err)
+		}
+	}
+	if _, err := makeCorpus("test", "jsonl", jsonl+"?titel=name"); err == nil {
+		t.Error("Unknown option accepted")
+	}
+}
+
+func TestRecordNumberIds(t *testing.T) {
+	useTempIndexDir(t)
+	jsonl := filepath.Join(t.TempDir(), "docs.jsonl")
+	ioutil.WriteFile(jsonl, []byte(`{"id": 12345678901234567891, "title": "Compiler construction"}
+{"id": 3, "title": "Sorting records on tapes"}
+`), 0644)
+	corpus, err := makeCorpus("test", "jsonl", jsonl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	search := ParseCorpus(context.Background(), corpus, map[string]bool{})
+	// ids above 2^53 aren't rounded
+	if id, ok := search.docId("12345678901234567891"); !ok || id != 0 {
+		t.Errorf("Document found as %d, %v", id, ok)
+	}
+	if id, ok := search.docId("3"); !ok || id != 1 {
+		t.Errorf("Document 3 found as %d, %v", id, ok)
+	}
+}
+
+// failingReader fails after its content is read, as a broken disk
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) { return 0, errors.New("read failed") }
+
+func TestCSVReadError(t *testing.T) {
+	s := &RecordScanner{mapping: recordMapping{Comma: ','}, toScan: make(chan rawRecord, 10)}
+	r := io.MultiReader(strings.NewReader("title,text\nCompiler,\"unclosed\nSorting,tapes\n"), failingReader{})
+	if err := s.readCSV(context.Background(), r, "docs.csv"); err == nil || !strings.Contains(err.Error(), "read failed") {
+		t.Errorf("Read error returned as %v", err)
+	}
+}
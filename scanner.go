@@ -1,6 +1,9 @@
 package main
 
-import "unicode"
+import (
+	"context"
+	"unicode"
+)
 
 var eof = rune(0)
 
@@ -8,8 +11,72 @@ func tokenMember(ch rune) bool {
 	return unicode.IsLetter(ch) || unicode.IsDigit(ch) || ch == '\'' || ch == '-' || ch == '/'
 }
 
-// Scanner is an interface reading words one at a time
-// implemented by CACMscanner and CS276Scanner
+// Scanner is an interface indexing documents and sending their metadata
+// implemented by CACMScanner and CS276Scanner, the channel is closed once all are sent
+// or once the documents being indexed are sent when ctx is cancelled
 type Scanner interface {
-	Scan(c chan *Document)
+	Scan(ctx context.Context, c chan metadata)
+}
+
+// resumer is a scanner that can start where the previous scans of its source stopped
+// positions are saved with the index, see Search.Resume
+type resumer interface {
+	// resume sets the positions where the previous scans stopped, before the scan
+	resume(positions map[string]int64)
+	// positions returns the positions where the scan stopped, after the scan
+	positions() map[string]int64
+}
+
+// orderedIds is true when documents scanned by concurrent workers get their ids in the order they are read
+// so indexing the same corpus twice writes the same index files, see turn
+var orderedIds bool
+
+// turn orders the ids of the documents indexed by concurrent workers
+// the goroutine reading the items of a scan gives each one a turn chained to the one of the previous item
+// workers parse their items in parallel, then wait for their turn to take the next id
+// only taking ids is sequential, the postings are still added concurrently
+type turn struct {
+	prev, done chan struct{}
+}
+
+// wait waits for the previous item to have its id
+func (t turn) wait() {
+	if t.prev != nil {
+		<-t.prev
+	}
+}
+
+// pass lets the next item take its id
+func (t turn) pass() {
+	if t.done != nil {
+		close(t.done)
+	}
+}
+
+// skip passes the turn of an item without document, after the previous item
+func (t turn) skip() {
+	t.wait()
+	t.pass()
+}
+
+// turns hands out the turns of a scan, in the order the items are read
+type turns struct {
+	last chan struct{}
+}
+
+func newTurns() *turns {
+	last := make(chan struct{})
+	close(last)
+	return &turns{last: last}
+}
+
+// next returns the turn of the next item read, a turn that never waits when ids aren't ordered
+func (t *turns) next() turn {
+	if !orderedIds {
+		return turn{}
+	}
+	done := make(chan struct{})
+	next := turn{prev: t.last, done: done}
+	t.last = done
+	return next
 }
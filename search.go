@@ -2,14 +2,36 @@ package main
 
 import (
 	"encoding/gob"
+	"fmt"
+	"io"
+	"log"
+	"math"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
 // Result is a document as returned by a Search
 type Result struct {
-	Name string
-	Url  string
+	Id int
+	// ExternalId is the id of the document in its corpus, see Document.External
+	ExternalId string
+	Name       string
+	Url        string
+	// Score is the vectorial score of the document, 0 for boolean queries
+	Score float64 `json:",omitempty"`
+	// Date is the publication date of the document, see documentDate
+	Date int `json:",omitempty"`
+	// Snippet is the passages of the document matching the query
+	Snippet []Fragment `json:",omitempty"`
+}
+
+// Published returns the publication date of the result, as "December 1958"
+func (r Result) Published() string {
+	return formatDate(r.Date)
 }
 
 // Ref is a reference to a document
@@ -26,46 +48,241 @@ type Search struct {
 	// Tokens stores the number of token for each document
 	// Only used for heaps law so it's no serialized
 	Tokens []int
-	// Index is a trie of token document pointers
-	Index *Root
-	// Size is the total number of documents
+	// Segments are the immutable parts of the index, ordered by document ids
+	// the slice is replaced, never updated in place, when segments are added or merged
+	Segments []*Segment
+	// mu protects Segments and gen
+	mu sync.RWMutex
+	// gen is the number of the last segment created
+	gen int
+	// merge wakes up the background merger
+	merge chan bool
+	// Size is the total number of documents, without the deleted ones
 	Size int
-	// Titles stores document title
-	Titles []string
 	// CW is a set of common words
 	CW map[string]bool
-	// toUrl generates URL from id and title, the function depends of the corpus
-	toUrl func(int, string) string
+	// corpus is the kind of documents indexed, it builds their urls, snippets and pages
+	// searches unserialized from an unknown kind have none
+	corpus Corpus
+	// Graph is the citation graph of the corpus, nil if its documents have no links
+	Graph *Graph
+	// Resume are the positions where the scans of the sources stopped, for resumable scanners
+	Resume map[string]int64
 }
 
 func emptySearch(corpus string, cw map[string]bool) *Search {
 	return &Search{Corpus: corpus, CW: cw}
 }
 
-// AddDocMetaData adds a parsed document metadata
-func (s *Search) AddDocMetaData(m metadata) {
-	size := len(s.Tokens)
-	id := m.id
-	if id == size {
-		s.Tokens = append(s.Tokens, m.tokens)
-		s.Titles = append(s.Titles, m.title)
-	} else if id < size {
-		s.Tokens[id] = m.tokens
-		s.Titles[id] = m.title
-	} else {
-		for i := size; i < id; i++ {
-			s.Tokens = append(s.Tokens, 0)
-			s.Titles = append(s.Titles, "")
+// addTokens stores the number of tokens of a parsed document
+func (s *Search) addTokens(m metadata) {
+	// Documents can arrive out of order, the slice is grown to fit
+	for len(s.Tokens) <= m.id {
+		s.Tokens = append(s.Tokens, 0)
+	}
+	s.Tokens[m.id] = m.tokens
+}
+
+// segments returns the current list of segments
+func (s *Search) segments() []*Segment {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.Segments
+}
+
+// addSegment adds a new segment after the existing ones and persists the list
+func (s *Search) addSegment(seg *Segment) {
+	s.mu.Lock()
+	s.Segments = append(s.Segments[:len(s.Segments):len(s.Segments)], seg)
+	s.serializeManifest()
+	s.mu.Unlock()
+	s.requestMerge()
+}
+
+// newSegment returns an empty segment for documents following the existing ones
+func (s *Search) newSegment() *Segment {
+	trie := newTrieFrom(s.nextID())
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return newSegment(s.newSegmentName(), trie)
+}
+
+// newSegmentName returns an unused segment name, s.mu must be held
+func (s *Search) newSegmentName() string {
+	s.gen++
+	return fmt.Sprintf("%s.%d", s.Corpus, s.gen)
+}
+
+// segment returns the segment holding the document id
+func (s *Search) segment(id int) *Segment {
+	segs := s.segments()
+	return segs[findSegment(segs, id)]
+}
+
+// findSegment returns the index of the segment holding the document id
+// or len(segs) if it doesn't exist
+func findSegment(segs []*Segment, id int) int {
+	return sort.Search(len(segs), func(i int) bool {
+		return segs[i].First+len(segs[i].Titles) > id
+	})
+}
+
+// Delete marks documents as deleted, they are filtered from all results
+// and their refs are purged when their segment is merged
+// the existing documents are deleted even if some ids are missing, they are listed in the error
+// Stat.Tokens and Stat.Vocabulary aren't updated, they describe all the text indexed
+func (s *Search) Delete(ids ...int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var missing []string
+	var deleted int
+	for _, id := range ids {
+		i := findSegment(s.Segments, id)
+		if id < 0 || i == len(s.Segments) {
+			missing = append(missing, strconv.Itoa(id))
+			continue
+		}
+		seg := s.Segments[i]
+		if seg.isDeleted(id) {
+			continue
+		}
+		// segments are shared with running queries, so they are copied
+		updated := *seg
+		updated.Deleted = seg.Deleted.with(id - seg.First)
+		updated.serializeDeleted()
+		segs := make([]*Segment, len(s.Segments))
+		copy(segs, s.Segments)
+		segs[i] = &updated
+		s.Segments = segs
+		s.Size--
+		deleted++
+	}
+	if deleted > 0 {
+		s.Stat.Documents = s.Size
+		s.serializeMeta()
+		s.requestMerge()
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%s has no document %s", s.Corpus, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// title returns the title of a document
+func (s *Search) title(id int) string {
+	seg := s.segment(id)
+	return seg.Titles[id-seg.First]
+}
+
+// date returns the publication date of a document, 0 when unknown
+func (s *Search) date(id int) int {
+	return s.segment(id).date(id)
+}
+
+// externalId returns the external id of a document, the one of its url and of the qrels of the corpus
+func (s *Search) externalId(id int) string {
+	seg := s.segment(id)
+	return seg.Externals[id-seg.First]
+}
+
+// docId returns the id of the document of an external id, false if there is none or it's deleted
+// when documents were indexed again under the same external id, the last one is found
+func (s *Search) docId(external string) (int, bool) {
+	segs := s.segments()
+	for i := len(segs) - 1; i >= 0; i-- {
+		seg := segs[i]
+		id, ok := seg.ids[external]
+		if !ok {
+			continue
+		}
+		// the last document of the segment may be deleted, not the ones indexed before it
+		for ; id >= seg.First; id-- {
+			if seg.Externals[id-seg.First] == external && !seg.isDeleted(id) {
+				return id, true
+			}
 		}
-		s.Tokens = append(s.Tokens, m.tokens)
-		s.Titles = append(s.Titles, m.title)
 	}
+	return 0, false
+}
+
+// docIDs returns the ids of the documents not deleted, by external id
+func (s *Search) docIDs() map[string]int {
+	ids := make(map[string]int, s.Size)
+	for _, seg := range s.segments() {
+		for i, external := range seg.Externals {
+			if !seg.Deleted.has(i) {
+				ids[external] = seg.First + i
+			}
+		}
+	}
+	return ids
+}
+
+// result returns the result of a document, without score
+func (s *Search) result(id int) Result {
+	title := s.title(id)
+	return Result{Id: id, ExternalId: s.externalId(id), Name: title, Url: s.url(id)}
+}
+
+// get returns the references for a word across all segments
+// segments are searched in parallel, they hold increasing ids
+// so the lists only need to be concatenated
+func (s *Search) get(w string) []Ref {
+	segs := s.segments()
+	if len(segs) == 1 {
+		return segs[0].get(w)
+	}
+	results := make([][]Ref, len(segs))
+	// Semaphore channel to wait for all segments
+	sem := make(chan bool)
+	for i, seg := range segs {
+		go func(i int, seg *Segment) {
+			results[i] = seg.get(w)
+			sem <- true
+		}(i, seg)
+	}
+	for range segs {
+		<-sem
+	}
+	var length int
+	for _, refs := range results {
+		length += len(refs)
+	}
+	refs := make([]Ref, 0, length)
+	for _, res := range results {
+		refs = append(refs, res...)
+	}
+	return refs
+}
+
+// idf returns the inverse document frequency of a term appearing in df documents
+// it's calculated at query time so adding documents doesn't require rewriting the index
+func (s *Search) idf(df int) float64 {
+	return math.Log(float64(s.Size) / float64(df))
+}
+
+// nextID returns the id the next added document will get
+func (s *Search) nextID() int {
+	segs := s.segments()
+	return segs[len(segs)-1].Index.count
+}
+
+// newTerms counts the words of trie that aren't in a segment of the search
+func (s *Search) newTerms(trie *Root) int {
+	var count int
+	trie.walk(func(w string, refs []Ref) {
+		if len(s.get(w)) == 0 {
+			count++
+		}
+	})
+	return count
 }
 
 // IndexSize returns the term -> Document index size
 // for document with ID < maxID
+// It's only used when building the index so there is a single segment
 func (s *Search) IndexSize(maxID int) int {
-	return s.Index.getInfIndex(maxID)
+	return s.Segments[0].Index.getInfIndex(maxID)
 }
 
 // TokenSize returns the total number of token
@@ -97,52 +314,67 @@ func (s *Search) refToResult(refs []Ref) []Result {
 	for i, ref := range refs {
 		// Because result are ordered this prevent printing twice the same doc
 		if i == 0 || ref.Id != refs[i-1].Id {
-			results = append(results,
-				Result{s.Titles[ref.Id], s.toUrl(ref.Id, s.Titles[ref.Id])})
+			results = append(results, s.result(ref.Id))
 		}
 	}
 	return results
 }
 
 // Serialize a search struct to a file
-// we only serialize the index, the titles and the urls list
+// we only serialize the segments, the titles and the urls list
 // no need to consider the tokens since they only serve to calculate HEAP law
 func (s *Search) Serialize() {
 	now := time.Now()
-	titles, err := os.Create("indexes/" + s.Corpus + ".titles")
+	cw, err := createIndexFile(s.Corpus + ".cw")
 	if err != nil {
 		panic(err)
 	}
-	defer titles.Close()
-	en := gob.NewEncoder(titles)
-	err = en.Encode(s.Titles)
+	defer cw.Close()
+	en := gob.NewEncoder(cw)
+	err = en.Encode(s.CW)
 	if err != nil {
 		panic(err)
 	}
-	titles.Close()
+	cw.commit()
+
+	for _, seg := range s.Segments {
+		seg.Serialize()
+	}
+	s.serializeManifest()
+	s.serializeGraph()
+	s.Perf.Serialization = time.Since(now)
+	s.Perf = s.Perf.getFinalValues()
 
-	cw, err := os.Create("indexes/" + s.Corpus + ".cw")
+	s.serializeMeta()
+}
+
+// serializeManifest saves the list of segments names
+// s.mu must be held when the search is in use
+func (s *Search) serializeManifest() {
+	names := make([]string, len(s.Segments))
+	for i, seg := range s.Segments {
+		names[i] = seg.Name
+	}
+	manifest, err := createIndexFile(s.Corpus + ".segments")
 	if err != nil {
 		panic(err)
 	}
-	defer cw.Close()
-	en = gob.NewEncoder(cw)
-	err = en.Encode(s.CW)
+	defer manifest.Close()
+	en := gob.NewEncoder(manifest)
+	err = en.Encode(names)
 	if err != nil {
 		panic(err)
 	}
-	cw.Close()
-
-	s.Index.Serialize(s.Corpus)
-	s.Perf.Serialization = time.Since(now)
-	s.Perf = s.Perf.getFinalValues()
+	manifest.commit()
+}
 
-	meta, err := os.Create("indexes/" + s.Corpus + ".meta")
+func (s *Search) serializeMeta() {
+	meta, err := createIndexFile(s.Corpus + ".meta")
 	if err != nil {
 		panic(err)
 	}
 	defer meta.Close()
-	en = gob.NewEncoder(meta)
+	en := gob.NewEncoder(meta)
 	err = en.Encode(s.Stat)
 	if err != nil {
 		panic(err)
@@ -151,31 +383,58 @@ func (s *Search) Serialize() {
 	if err != nil {
 		panic(err)
 	}
-	meta.Close()
-}
-
-// UnserializeSearch reloads what's needed from disk
-func UnserializeSearch(name string) *Search {
-	s := &Search{}
-	s.Corpus = name
-	titles, err := os.Open("indexes/" + name + ".titles")
+	var config corpusConfig
+	if s.corpus != nil {
+		config = corpusConfig{Kind: s.corpus.Kind(), Source: s.corpus.Source()}
+	}
+	err = en.Encode(config)
 	if err != nil {
 		panic(err)
 	}
-	defer titles.Close()
-	en := gob.NewDecoder(titles)
-	err = en.Decode(&s.Titles)
+	err = en.Encode(s.Resume)
 	if err != nil {
 		panic(err)
 	}
-	titles.Close()
+	meta.commit()
+}
+
+// corpusConfig is the kind and source of the corpus of an index, saved in its .meta
+type corpusConfig struct {
+	Kind   string
+	Source string
+}
+
+// loadCorpus returns the corpus of an index
+// the configured corpus of the same name is used first, then the one saved with the index
+// indexes saved before corpora were configurable are named after their kind
+func loadCorpus(name string, config corpusConfig) Corpus {
+	for _, c := range configuredCorpora() {
+		if c.Name() == name {
+			return c
+		}
+	}
+	if config.Kind == "" {
+		config.Kind = name
+	}
+	c, err := makeCorpus(name, config.Kind, config.Source)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	return c
+}
 
-	meta, err := os.Open("indexes/" + name + ".meta")
+// UnserializeSearch reloads what's needed from disk
+// indexes built before segments existed are refused, their weights include the idf applied at query time
+func UnserializeSearch(name string) (*Search, error) {
+	s := &Search{}
+	s.Corpus = name
+	meta, err := os.Open(indexFile(name + ".meta"))
 	if err != nil {
 		panic(err)
 	}
 	defer meta.Close()
-	en = gob.NewDecoder(meta)
+	en := gob.NewDecoder(meta)
 	err = en.Decode(&s.Stat)
 	if err != nil {
 		panic(err)
@@ -184,9 +443,20 @@ func UnserializeSearch(name string) *Search {
 	if err != nil {
 		panic(err)
 	}
+	// indexes saved before corpora were configurable have no corpus config
+	var config corpusConfig
+	err = en.Decode(&config)
+	if err != nil && err != io.EOF {
+		panic(err)
+	}
+	s.corpus = loadCorpus(name, config)
+	err = en.Decode(&s.Resume)
+	if err != nil && err != io.EOF {
+		panic(err)
+	}
 	meta.Close()
 
-	cw, err := os.Open("indexes/" + name + ".cw")
+	cw, err := os.Open(indexFile(name + ".cw"))
 	if err != nil {
 		panic(err)
 	}
@@ -198,6 +468,31 @@ func UnserializeSearch(name string) *Search {
 	}
 	cw.Close()
 
-	s.Index = UnserializeTrie(name)
-	return s
+	var names []string
+	manifest, err := os.Open(indexFile(name + ".segments"))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%s index was built by an older version storing tf-idf weights, rebuild it with -index", name)
+	}
+	if err != nil {
+		panic(err)
+	}
+	defer manifest.Close()
+	en = gob.NewDecoder(manifest)
+	err = en.Decode(&names)
+	if err != nil {
+		panic(err)
+	}
+	manifest.Close()
+	s.unserializeGraph()
+	for _, seg := range names {
+		s.Segments = append(s.Segments, UnserializeSegment(seg))
+		s.Size += s.Segments[len(s.Segments)-1].live()
+		// keep track of the highest segment number, merged segments get new ones
+		if i := strings.LastIndex(seg, "."); i >= 0 {
+			if gen, err := strconv.Atoi(seg[i+1:]); err == nil && gen > s.gen {
+				s.gen = gen
+			}
+		}
+	}
+	return s, nil
 }
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"context"
+	"encoding/gob"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/surgebase/porter2"
+)
+
+const testCACM = `.I 1
+.T
+Compiler construction for algebraic languages
+.W
+A compiler for an algebraic language
+.I 2
+.T
+Sorting records on tapes
+`
+
+const testCACMAddition = `.I 3
+.T
+Incremental compiler design
+.I 4
+.T
+Merging sorted tapes
+`
+
+// useTempIndexDir writes the indexes of a test to a temporary folder, removed once the test ends
+func useTempIndexDir(t *testing.T) {
+	old := indexDir
+	indexDir = t.TempDir()
+	t.Cleanup(func() { indexDir = old })
+}
+
+func TestAppendSegment(t *testing.T) {
+	useTempIndexDir(t)
+	search := ParseCACM(strings.NewReader(testCACM), map[string]bool{})
+	search.Corpus = "test"
+	AppendCACM(search, strings.NewReader(testCACMAddition))
+	if search.Size != 4 || len(search.Segments) != 2 {
+		t.Fatalf("Incorrect size after append: %d documents, %d segments",
+			search.Size, len(search.Segments))
+	}
+	if search.nextID() != 4 || search.Segments[1].First != 2 {
+		t.Fatal("Incorrect ids after append")
+	}
+	// the added segment is saved, its documents are read from the store file
+	if text, err := search.document(3); err != nil || string(text) != ".I 4\n.T\nMerging sorted tapes\n" {
+		t.Errorf("Document read as %q, %v", text, err)
+	}
+	refs := search.BooleanSearch("compiler")
+	if len(refs) != 2 || strings.TrimSpace(refs[1].Name) != "Incremental compiler design" {
+		t.Fatalf("Incorrect result across segments: %v", refs)
+	}
+	// idf is calculated with the full document count
+	// the words are only in titles, the title field isn't boosted to compare scores
+	defer func(boosts []fieldBoost) { fieldBoosts = boosts }(fieldBoosts)
+	fieldBoosts = nil
+	vec := VectorQuery(search, "tapes", raw)
+	if len(vec) != 2 || vec[0].Weights[raw] != search.idf(2) {
+		t.Fatalf("Incorrect tf-idf across segments: %v", vec)
+	}
+}
+
+func TestLegacyIndex(t *testing.T) {
+	useTempIndexDir(t)
+	search := ParseCACM(strings.NewReader(testCACM), map[string]bool{})
+	search.Serialize()
+	if loaded, err := UnserializeSearch(search.Corpus); err != nil || loaded.Size != 2 {
+		t.Fatalf("Index loaded with %v", err)
+	}
+	// indexes built before segments have no manifest, their weights are tf-idf
+	os.Remove(indexFile(search.Corpus + ".segments"))
+	if _, err := UnserializeSearch(search.Corpus); err == nil {
+		t.Error("Legacy index loaded")
+	}
+}
+
+func TestCACMFields(t *testing.T) {
+	useTempIndexDir(t)
+	search := ParseCACM(strings.NewReader(`.I 1
+.T
+Time Sharing Systems. A Survey
+.W
+The optimization of time-shared systems.
+The user input process of TSS.
+.A
+.B
+CACM December, 1958
+.A
+De Millo, R.A.
+Perlis, A. J.
+.N
+CA581203 JB March 22, 1978
+`), map[string]bool{})
+	search.Corpus = "test"
+	if title := strings.TrimSpace(search.title(0)); title != "Time Sharing Systems. A Survey" {
+		t.Errorf("Title read as %q", title)
+	}
+	// dots inside a field don't end it
+	if len(search.get("TSS")) != 1 {
+		t.Error("Abstract read only up to the first sentence")
+	}
+	for _, author := range []string{"author:demillo", "author:perlis"} {
+		if len(search.get(author)) != 1 {
+			t.Errorf("Author %s not indexed", author)
+		}
+	}
+	if len(search.get("CA581203")) != 0 {
+		t.Error("Notes indexed")
+	}
+	if refs := search.get(stem("optimization")); len(refs) != 1 || refs[0].Weights[raw] != 1 {
+		t.Errorf("Incorrect term frequency: %v", refs)
+	}
+
+	// words are searched in a single field with the field:value syntax
+	fields := map[string]int{
+		"title:survey author:Perlis":   1,
+		"abstract:survey":              0,
+		"title:optimization year:1958": 1,
+		"year:1959":                    0,
+		// year ranges filter the results
+		"title:survey year:1950..1960": 1,
+		"title:survey year:1960..":     0,
+	}
+	for query, count := range fields {
+		if refs := VectorQuery(search, query, raw); len(refs) != count {
+			t.Errorf("Query %q returned %d documents, expected %d", query, len(refs), count)
+		}
+	}
+	if refs := BooleanQuery(search, "author:perlis AND year:1958 AND NOT title:optimization"); len(refs) != 1 {
+		t.Errorf("Boolean field query returned %d documents, expected 1", len(refs))
+	}
+	if refs := BooleanQuery(search, "author:perlis year:..1957"); len(refs) != 0 {
+		t.Errorf("Boolean query returned %d documents out of the year range", len(refs))
+	}
+	// fields only searched with field:value can't be boosted
+	for _, list := range []string{"author=1", "title=0.5,year=1"} {
+		if err := parseBoosts(list); err == nil {
+			t.Errorf("Boosts %q accepted", list)
+		}
+	}
+	if text, err := search.document(0); err != nil || !strings.HasPrefix(string(text), ".I 1\n.T\nTime Sharing") ||
+		!strings.HasSuffix(string(text), "1978\n") {
+		t.Errorf("Document stored as %q, %v", text, err)
+	}
+	if date := search.date(0); date != 195812 || formatDate(date) != "December 1958" {
+		t.Errorf("Date read as %d", date)
+	}
+}
+
+func TestCitationGraph(t *testing.T) {
+	useTempIndexDir(t)
+	// 2 and 3 cite 1, 3 cites 2, each link is written in both records
+	search := ParseCACM(strings.NewReader(`.I 1
+.T
+Algol
+.X
+1	5	1
+2	5	1
+3	5	1
+.I 2
+.T
+Algol compilers
+.X
+1	5	2
+2	5	2
+3	5	2
+.I 3
+.T
+Algol compilers again
+.X
+1	5	3
+2	5	3
+2	4	3
+`), map[string]bool{})
+	g := search.Graph
+	if g == nil || len(g.Cites[2]) != 2 || len(g.CitedBy[0]) != 2 || len(g.Cites[0]) != 0 {
+		t.Fatalf("Incorrect citation graph: %+v", g)
+	}
+	if !(g.PageRank[0] > g.PageRank[1] && g.PageRank[1] > g.PageRank[2]) {
+		t.Errorf("Incorrect PageRank: %v", g.PageRank)
+	}
+	if !(g.Authority[0] > g.Authority[2] && g.Hub[2] > g.Hub[0]) {
+		t.Errorf("Incorrect HITS scores: hubs %v authorities %v", g.Hub, g.Authority)
+	}
+
+	// the most cited document gets the highest boost
+	refs := []Ref{{Id: 2, Weights: weights{1}}, {Id: 0, Weights: weights{1}}}
+	search.mixStatic(refs, raw, "pagerank", 0.5)
+	if refs[1].Weights[raw] != 1.5 || refs[0].Weights[raw] >= refs[1].Weights[raw] {
+		t.Errorf("Incorrect static scores mix: %v", refs)
+	}
+}
+
+func TestCitationExternalIds(t *testing.T) {
+	useTempIndexDir(t)
+	// numbers don't start at 1 and aren't contiguous, 25 cites 10, 40 cites 10 and 25
+	search := ParseCACM(strings.NewReader(`.I 10
+.T
+Algol
+.X
+10	5	10
+25	5	10
+40	5	10
+.I 25
+.T
+Algol compilers
+.X
+10	5	25
+25	5	25
+40	5	25
+.I 40
+.T
+Algol compilers again
+.X
+10	5	40
+25	5	40
+`), map[string]bool{})
+	g := search.Graph
+	if g == nil || !reflect.DeepEqual(g.Cites, [][]int{nil, {0}, {0, 1}}) {
+		t.Fatalf("Incorrect citations: %+v", g)
+	}
+	if !reflect.DeepEqual(g.CitedBy[0], []int{1, 2}) || !reflect.DeepEqual(g.CitedBy[1], []int{2}) {
+		t.Errorf("Incorrect cited by: %v", g.CitedBy)
+	}
+}
+
+func TestMergeSegments(t *testing.T) {
+	segs := make([]*Segment, 0, mergeFactor+1)
+	// one big segment then small ones
+	sizes := []int{minSegmentSize * mergeFactor, 1, 2, 1, 3}
+	var first int
+	for i, size := range sizes {
+		trie := newTrieFrom(first)
+		seg := newSegment(strconv.Itoa(i), trie)
+		for id := first; id < first+size; id++ {
+			var wf weights
+			wf[raw] = float64(id)
+			trie.add(testWords[id%len(testWords)], id, wf)
+			seg.AddDocMetaData(metadata{id: id, title: strconv.Itoa(id)})
+		}
+		trie.count = first + size
+		first += size
+		segs = append(segs, seg)
+	}
+	i, j := findMerge(segs)
+	if i != 1 || j != 1+mergeFactor {
+		t.Fatalf("Incorrect segments chosen for merge: %d to %d", i, j)
+	}
+	merged := mergeSegments("merged", segs[i:j])
+	if merged.First != segs[1].First || len(merged.Titles) != 7 || merged.Index.count != first {
+		t.Fatal("Incorrect merged segment bounds")
+	}
+	for _, w := range testWords {
+		var expected []Ref
+		for _, seg := range segs[i:j] {
+			expected = append(expected, seg.Index.get(w)...)
+		}
+		refs := merged.Index.get(w)
+		if len(refs) != len(expected) {
+			t.Fatalf("Incorrect refs for %s after merge", w)
+		}
+		for k := range refs {
+			if refs[k] != expected[k] {
+				t.Fatalf("Incorrect refs for %s after merge", w)
+			}
+		}
+	}
+}
+
+func TestMergeReaders(t *testing.T) {
+	useTempIndexDir(t)
+	search := ParseCACM(strings.NewReader(testCACM), map[string]bool{})
+	search.Serialize()
+	for i := 1; i < mergeFactor; i++ {
+		AppendCACM(search, strings.NewReader(testCACMAddition))
+	}
+	// a query reads the first segment while it's merged
+	read, unread := search.Segments[0], search.Segments[1]
+	read.acquire()
+	if !search.mergeOnce() || len(search.Segments) != 1 {
+		t.Fatalf("%d segments after merge", len(search.Segments))
+	}
+	if _, err := os.Stat(indexFile(unread.Name + ".docs")); !os.IsNotExist(err) {
+		t.Errorf("Files of the merged segment %s kept", unread.Name)
+	}
+	if text, err := read.document(0); err != nil || !strings.HasPrefix(string(text), ".I 1") {
+		t.Errorf("Document read as %q, %v during the merge", text, err)
+	}
+	read.release()
+	if _, err := os.Stat(indexFile(read.Name + ".docs")); !os.IsNotExist(err) {
+		t.Errorf("Files of the merged segment %s kept after its last reader", read.Name)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	useTempIndexDir(t)
+	search := ParseCACM(strings.NewReader(testCACM), map[string]bool{})
+	search.Corpus = "test"
+	AppendCACM(search, strings.NewReader(testCACMAddition))
+	if err := search.Delete(0); err != nil {
+		t.Fatal(err)
+	}
+	if search.Delete(4) == nil {
+		t.Fatal("Deleting a missing document should fail")
+	}
+	if search.Size != 3 || search.Stat.Documents != 3 {
+		t.Fatal("Incorrect document count after delete")
+	}
+	// the existing documents are deleted even if others are missing
+	if search.Delete(5, 1) == nil {
+		t.Fatal("Deleting a missing document should fail")
+	}
+	if search.Size != 2 || !search.Segments[0].isDeleted(1) {
+		t.Fatal("Existing document not deleted")
+	}
+	var saved Stat
+	meta, err := os.Open(indexFile(search.Corpus + ".meta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer meta.Close()
+	if err := gob.NewDecoder(meta).Decode(&saved); err != nil || saved.Documents != 2 {
+		t.Fatalf("Saved %d documents, %v", saved.Documents, err)
+	}
+	results := search.BooleanSearch("compiler")
+	if len(results) != 1 || strings.TrimSpace(results[0].Name) != "Incremental compiler design" {
+		t.Fatalf("Deleted document returned: %v", results)
+	}
+	// once merged the refs are purged but the document stays deleted
+	merged := mergeSegments("merged", search.Segments)
+	if len(merged.Index.get(porter2.Stem("compiler"))) != 1 {
+		t.Fatal("Deleted refs not purged")
+	}
+	if merged.live() != 2 || merged.Purged != 2 || !merged.isDeleted(0) {
+		t.Fatal("Incorrect deleted documents after merge")
+	}
+}
+
+func TestReplace(t *testing.T) {
+	useTempIndexDir(t)
+	search := ParseCACM(strings.NewReader(testCACM), map[string]bool{})
+	search.Corpus = "test"
+	// the document is kept when its replacement can't be read
+	if replaceDoc(context.Background(), search, "1", filepath.Join(t.TempDir(), "missing.all")) == nil {
+		t.Fatal("Replacing by a missing file should fail")
+	}
+	if id, ok := search.docId("1"); !ok || id != 0 || search.Size != 2 {
+		t.Fatalf("Document 1 found as %d, %v after a failed replace", id, ok)
+	}
+	source := filepath.Join(t.TempDir(), "new.all")
+	ioutil.WriteFile(source, []byte(".I 1\n.T\nCompiler construction revised\n"), 0644)
+	if err := replaceDoc(context.Background(), search, "1", source); err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := search.docId("1"); id != 2 || search.Size != 2 || !search.Segments[0].isDeleted(0) {
+		t.Fatalf("Document 1 found as %d after replace", id)
+	}
+}
+
+func TestRebuildAfterDelete(t *testing.T) {
+	useTempIndexDir(t)
+	search := ParseCACM(strings.NewReader(testCACM), map[string]bool{})
+	search.Serialize()
+	if err := search.Delete(0); err != nil {
+		t.Fatal(err)
+	}
+	// the index rebuilt from scratch doesn't keep the deletions saved before
+	ParseCACM(strings.NewReader(testCACM), map[string]bool{}).Serialize()
+	loaded, err := UnserializeSearch(search.Corpus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Size != 2 || loaded.Segments[0].isDeleted(0) {
+		t.Errorf("Deletions kept after a rebuild, %d documents", loaded.Size)
+	}
+}
+
+func TestExternalIds(t *testing.T) {
+	useTempIndexDir(t)
+	search := ParseCACM(strings.NewReader(testCACM), map[string]bool{})
+	search.Corpus = "test"
+	// document 2 is indexed again after a new one
+	AppendCACM(search, strings.NewReader(".I 7\n.T\nIncremental compiler design\n.I 2\n.T\nSorting records on disks\n"))
+	if id, ok := search.docId("7"); !ok || id != 2 || search.url(id) != "/test/7" {
+		t.Fatalf("Document 7 found as %d, %v", id, ok)
+	}
+	if id, _ := search.docId("2"); id != 3 {
+		t.Fatalf("Document 2 found as %d instead of the last one indexed", id)
+	}
+	if err := search.Delete(3); err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := search.docId("2"); id != 1 {
+		t.Fatalf("Document 2 found as %d once deleted", id)
+	}
+	if ids := search.docIDs(); len(ids) != 3 || ids["1"] != 0 || ids["2"] != 1 {
+		t.Fatalf("Incorrect external ids %v", ids)
+	}
+	merged := mergeSegments("merged", search.Segments)
+	if merged.ids["7"] != 2 || merged.Externals[3] != "2" {
+		t.Fatal("Incorrect external ids after merge")
+	}
+	search.Segments = []*Segment{merged}
+	if id, _ := search.docId("2"); id != 1 {
+		t.Fatalf("Document 2 found as %d in the merged segment", id)
+	}
+}
@@ -0,0 +1,439 @@
+// Segment.go implements the segments composing a search index
+// a segment is an immutable trie with the titles of its documents
+// the ids of a segment are contiguous and follow the ones of the previous segment
+// so results from different segments can simply be concatenated
+// segments are merged in background following a tiered policy:
+// mergeFactor adjacent segments of the same tier (size range) are merged together
+// the external ids of the documents are kept with a map back to their ids, see Search.docId
+// Deleted documents are marked in a bitmap, the only part of a segment that changes
+// it's replaced by an updated copy, and the postings are purged when the segment is merged
+// the files of merged segments are removed once the queries reading them are done, see segmentFiles
+package main
+
+import (
+	"encoding/gob"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// mergeFactor is the number of segments of a same tier merged together
+	mergeFactor = 4
+	// minSegmentSize is the size under which all segments are in the lowest tier
+	minSegmentSize = 100
+	// maxDeletedRatio is the ratio of deleted documents over which a segment is rewritten
+	maxDeletedRatio = 0.2
+)
+
+// Segment is an immutable part of an index
+type Segment struct {
+	// Name is the base name of the segment files
+	Name string
+	// Index is a trie of token document pointers
+	Index *Root
+	// First is the id of the first document of the segment
+	First int
+	// Titles stores document title
+	Titles []string
+	// Dates stores the publication date of documents, 0 when unknown, see documentDate
+	Dates []int
+	// Externals stores the external ids of documents, see Document.External
+	// ids are the ids of the documents by external id, the last one indexed when ids repeat
+	Externals []string
+	ids       map[string]int
+	// Docs are the compressed texts of the documents until the segment is serialized
+	// Offsets are then the positions of the texts in the .docs file, see store.go
+	Docs    [][]byte
+	Offsets []int64
+	// Deleted is a bitmap of the deleted documents, indexed by id - First
+	Deleted bitmap
+	// Purged is the number of deleted documents whose refs aren't in the trie anymore
+	Purged int
+	// files counts the readers of the segment files, it's shared by the copies of the segment
+	files *segmentFiles
+}
+
+// segmentFiles counts the readers of the files of a segment
+// once the segment is merged away its files are removed by the last reader
+type segmentFiles struct {
+	mu      sync.Mutex
+	readers int
+	retired bool
+}
+
+func newSegment(name string, trie *Root) *Segment {
+	return &Segment{Name: name, Index: trie, First: trie.count, ids: make(map[string]int), files: &segmentFiles{}}
+}
+
+// AddDocMetaData adds a parsed document metadata
+func (seg *Segment) AddDocMetaData(m metadata) {
+	id := m.id - seg.First
+	// Documents can arrive out of order, the slice is grown to fit
+	for len(seg.Titles) <= id {
+		seg.Titles = append(seg.Titles, "")
+		seg.Dates = append(seg.Dates, 0)
+		seg.Externals = append(seg.Externals, "")
+	}
+	seg.Titles[id] = m.title
+	seg.Dates[id] = m.date
+	seg.setExternal(m.id, m.external)
+	if m.text != nil {
+		seg.addDocText(m.id, m.text)
+	}
+}
+
+// setExternal sets the external id of the document id
+func (seg *Segment) setExternal(id int, external string) {
+	if old, ok := seg.ids[external]; !ok || old < id {
+		seg.ids[external] = id
+	}
+	seg.Externals[id-seg.First] = external
+}
+
+// mapExternals builds the ids of the documents by external id
+func (seg *Segment) mapExternals() {
+	seg.ids = make(map[string]int, len(seg.Externals))
+	for i, external := range seg.Externals {
+		seg.setExternal(seg.First+i, external)
+	}
+}
+
+// date returns the publication date of the document id, 0 when unknown
+func (seg *Segment) date(id int) int {
+	return seg.Dates[id-seg.First]
+}
+
+// contains returns wether the document id is part of the segment
+func (seg *Segment) contains(id int) bool {
+	return id >= seg.First && id < seg.First+len(seg.Titles)
+}
+
+// isDeleted returns wether the document id has been deleted
+func (seg *Segment) isDeleted(id int) bool {
+	return seg.Deleted.has(id - seg.First)
+}
+
+// live returns the number of documents not deleted
+func (seg *Segment) live() int {
+	return len(seg.Titles) - seg.Deleted.count()
+}
+
+// get returns the references for a word, without deleted documents
+// filtering here means intersect, union or mergeWithTfIdf only see live documents
+// and the document frequency used for idf is correct
+func (seg *Segment) get(w string) []Ref {
+	refs := seg.Index.get(w)
+	if len(seg.Deleted) == 0 {
+		return refs
+	}
+	live := refs[:0]
+	for _, ref := range refs {
+		if !seg.isDeleted(ref.Id) {
+			live = append(live, ref)
+		}
+	}
+	return live
+}
+
+// tier returns the tier of a segment,
+// a segment of tier n holds less than minSegmentSize*mergeFactor^(n+1) documents
+func (seg *Segment) tier() int {
+	var t int
+	for size := seg.live() / minSegmentSize; size >= mergeFactor; size /= mergeFactor {
+		t++
+	}
+	return t
+}
+
+// findMerge returns the bounds of the first run of mergeFactor adjacent segments
+// in the same tier, or -1, -1 if there is none
+// only adjacent segments are merged so ids stay contiguous
+// a segment with too many deleted documents is rewritten alone
+func findMerge(segs []*Segment) (int, int) {
+	for i, seg := range segs {
+		pending := seg.Deleted.count() - seg.Purged
+		if float64(pending) > maxDeletedRatio*float64(len(seg.Titles)) {
+			return i, i + 1
+		}
+	}
+	start := 0
+	for i := 1; i <= len(segs); i++ {
+		if i-start == mergeFactor {
+			return start, i
+		}
+		if i < len(segs) && segs[i].tier() != segs[start].tier() {
+			start = i
+		}
+	}
+	return -1, -1
+}
+
+// mergeSegments builds a new segment holding the documents of segs
+// segs must be adjacent and ordered, refs of deleted documents are purged
+func mergeSegments(name string, segs []*Segment) *Segment {
+	trie := newTrieFrom(segs[0].First)
+	merged := newSegment(name, trie)
+	var hasStore bool
+	for _, seg := range segs {
+		hasStore = hasStore || seg.hasStore()
+	}
+	for _, seg := range segs {
+		// refs are added in increasing id order, so they are appended at the end
+		seg.Index.walk(func(w string, refs []Ref) {
+			for _, ref := range refs {
+				if !seg.isDeleted(ref.Id) {
+					trie.add(w, ref.Id, ref.Weights)
+				}
+			}
+		})
+		// deleted documents keep their ids, so they stay marked
+		offset := seg.First - merged.First
+		seg.Deleted.each(func(i int) {
+			merged.Deleted.set(i + offset)
+		})
+		for id := seg.First; id < seg.First+len(seg.Titles); id++ {
+			merged.Dates = append(merged.Dates, seg.date(id))
+		}
+		merged.Titles = append(merged.Titles, seg.Titles...)
+		merged.Externals = append(merged.Externals, seg.Externals...)
+		if hasStore {
+			merged.copyStore(seg)
+		}
+	}
+	merged.Purged = merged.Deleted.count()
+	trie.count = merged.First + len(merged.Titles)
+	merged.mapExternals()
+	return merged
+}
+
+// Serialize saves the segment trie, titles, dates, external ids and documents
+func (seg *Segment) Serialize() {
+	seg.Index.Serialize(seg.Name)
+	seg.serializeStore()
+
+	titles, err := createIndexFile(seg.Name + ".titles")
+	if err != nil {
+		panic(err)
+	}
+	defer titles.Close()
+	en := gob.NewEncoder(titles)
+	err = en.Encode(seg.Titles)
+	if err != nil {
+		panic(err)
+	}
+	err = en.Encode(seg.Dates)
+	if err != nil {
+		panic(err)
+	}
+	titles.commit()
+	seg.serializeExternals()
+
+	if len(seg.Deleted) > 0 {
+		seg.serializeDeleted()
+	} else if err := os.Remove(indexFile(seg.Name + ".del")); err != nil && !os.IsNotExist(err) {
+		// an index rebuilt from scratch must not keep previous deletions
+		panic(err)
+	}
+}
+
+// serializeExternals saves the external ids of the documents
+func (seg *Segment) serializeExternals() {
+	externals, err := createIndexFile(seg.Name + ".ids")
+	if err != nil {
+		panic(err)
+	}
+	defer externals.Close()
+	err = gob.NewEncoder(externals).Encode(seg.Externals)
+	if err != nil {
+		panic(err)
+	}
+	externals.commit()
+}
+
+// serializeDeleted saves the bitmap of deleted documents
+func (seg *Segment) serializeDeleted() {
+	deleted, err := createIndexFile(seg.Name + ".del")
+	if err != nil {
+		panic(err)
+	}
+	defer deleted.Close()
+	en := gob.NewEncoder(deleted)
+	err = en.Encode(seg.Deleted)
+	if err != nil {
+		panic(err)
+	}
+	err = en.Encode(seg.Purged)
+	if err != nil {
+		panic(err)
+	}
+	deleted.commit()
+}
+
+// remove deletes the segment files, once it has been merged
+func (seg *Segment) remove() {
+	for _, ext := range []string{".index", ".titles", ".ids", ".del", ".docs", ".offsets"} {
+		err := os.Remove(indexFile(seg.Name + ext))
+		// not all segments have deleted documents or a document store
+		if err != nil && !os.IsNotExist(err) {
+			log.Println(err)
+		}
+	}
+}
+
+// acquire keeps the segment files until release is called
+// it must be called while the segment is in the list of segments, i.e with Search.mu held
+func (seg *Segment) acquire() {
+	seg.files.mu.Lock()
+	seg.files.readers++
+	seg.files.mu.Unlock()
+}
+
+// release ends a read started by acquire, the files of a retired segment are removed by the last reader
+func (seg *Segment) release() {
+	seg.files.mu.Lock()
+	seg.files.readers--
+	last := seg.files.retired && seg.files.readers == 0
+	seg.files.mu.Unlock()
+	if last {
+		seg.remove()
+	}
+}
+
+// retire removes the segment files once it's been merged and no reader holds them anymore
+func (seg *Segment) retire() {
+	seg.files.mu.Lock()
+	seg.files.retired = true
+	unused := seg.files.readers == 0
+	seg.files.mu.Unlock()
+	if unused {
+		seg.remove()
+	}
+}
+
+// UnserializeSegment reloads a segment from files
+func UnserializeSegment(name string) *Segment {
+	seg := &Segment{Name: name, files: &segmentFiles{}}
+	titles, err := os.Open(indexFile(name + ".titles"))
+	if err != nil {
+		panic(err)
+	}
+	defer titles.Close()
+	en := gob.NewDecoder(titles)
+	err = en.Decode(&seg.Titles)
+	if err != nil {
+		panic(err)
+	}
+	err = en.Decode(&seg.Dates)
+	if err != nil {
+		panic(err)
+	}
+	titles.Close()
+
+	deleted, err := os.Open(indexFile(name + ".del"))
+	if err == nil {
+		defer deleted.Close()
+		en = gob.NewDecoder(deleted)
+		err = en.Decode(&seg.Deleted)
+		if err != nil {
+			panic(err)
+		}
+		err = en.Decode(&seg.Purged)
+		if err != nil {
+			panic(err)
+		}
+		deleted.Close()
+	}
+
+	externals, err := os.Open(indexFile(name + ".ids"))
+	if err != nil {
+		panic(err)
+	}
+	defer externals.Close()
+	err = gob.NewDecoder(externals).Decode(&seg.Externals)
+	if err != nil {
+		panic(err)
+	}
+	externals.Close()
+
+	seg.unserializeStore()
+	seg.Index = UnserializeTrie(name)
+	// The trie count is the id following the segment last document
+	seg.First = seg.Index.count - len(seg.Titles)
+	seg.mapExternals()
+	return seg
+}
+
+// StartMerger starts the goroutine merging the search segments in background
+func (s *Search) StartMerger() {
+	s.merge = make(chan bool, 1)
+	go func() {
+		for range s.merge {
+			for s.mergeOnce() {
+			}
+		}
+	}()
+	s.requestMerge()
+}
+
+// requestMerge wakes up the merger, if it's running
+func (s *Search) requestMerge() {
+	if s.merge == nil {
+		return
+	}
+	select {
+	case s.merge <- true:
+	default:
+		// a merge is already pending
+	}
+}
+
+// mergeOnce merges one run of segments, it returns false if nothing had to be merged
+func (s *Search) mergeOnce() bool {
+	s.mu.Lock()
+	segs := s.Segments
+	i, j := findMerge(segs)
+	if i < 0 {
+		s.mu.Unlock()
+		return false
+	}
+	name := s.newSegmentName()
+	s.mu.Unlock()
+
+	now := time.Now()
+	merged := mergeSegments(name, segs[i:j])
+	merged.Serialize()
+
+	s.mu.Lock()
+	// segments are only ever added at the end, and merged by this goroutine
+	// so segs[i:j] are still at the same place, but documents might have been deleted
+	var changed bool
+	for k := i; k < j; k++ {
+		if s.Segments[k] == segs[k] {
+			continue
+		}
+		changed = true
+		offset := segs[k].First - merged.First
+		s.Segments[k].Deleted.each(func(i int) {
+			merged.Deleted.set(i + offset)
+		})
+	}
+	if changed {
+		merged.serializeDeleted()
+	}
+	updated := make([]*Segment, 0, len(s.Segments)-(j-i)+1)
+	updated = append(updated, s.Segments[:i]...)
+	updated = append(updated, merged)
+	updated = append(updated, s.Segments[j:]...)
+	s.Segments = updated
+	s.serializeManifest()
+	s.mu.Unlock()
+
+	// queries started before the swap may still read the merged segments
+	for _, seg := range segs[i:j] {
+		seg.retire()
+	}
+	log.Printf("%s %d segments merged in %s \n", s.Corpus, j-i, time.Since(now).String())
+	return true
+}
@@ -5,8 +5,8 @@ import (
 	"html/template"
 	"log"
 	"net/http"
+	"net/url"
 	"path"
-	"strconv"
 	"time"
 
 	humanize "github.com/dustin/go-humanize"
@@ -20,16 +20,32 @@ const (
 
 type answer struct {
 	Query string
-	// A small "hack" to keep the same buttons checked
-	CS276     bool
+	// Corpus is the corpus searched, Corpora the ones that can be
+	Corpus    string
+	Corpora   []string
 	Vectorial bool
 	Weight    string
-	Results   []Result
-	Time      string
+	// Sort is the order of the results, "date" or relevance when empty
+	Sort    string
+	Results []Result
+	Time    string
 	// Links to other results in the query set
 	Prev string
 	Next string
 	Size int
+	// Missing are the shards which didn't answer
+	Missing []string
+}
+
+// textDoc is a document of the store shown as plain text
+type textDoc struct {
+	Title string
+	// Source is the url of the original document
+	Source string
+	Text   string
+	// Cites are the documents of the corpus it links to, CitedBy the ones linking to it
+	Cites   []Result
+	CitedBy []Result
 }
 
 func printDuration(dur time.Duration) string {
@@ -37,7 +53,8 @@ func printDuration(dur time.Duration) string {
 	return ((dur / time.Millisecond) * time.Millisecond).String()
 }
 
-func serve(cacm, cs276 *Search, precall *PreCallCalculator) {
+// serve registers the pages of the searches, riw already listens, see main
+func serve(list []*Search, precall *PreCallCalculator) {
 	prettyfier := template.FuncMap{
 		"duration": printDuration,
 		"size":     humanize.Bytes,
@@ -45,136 +62,77 @@ func serve(cacm, cs276 *Search, precall *PreCallCalculator) {
 
 	pattern := path.Join("templates", "*.html")
 	templates := template.Must(template.New("base").Funcs(prettyfier).ParseGlob(pattern))
-
-	stats := []*Stat{
-		&cacm.Stat,
-		&cs276.Stat,
-	}
-	perfs := []*Perf{
-		&cacm.Perf,
-		&cs276.Perf,
+	render := func(w http.ResponseWriter, name string, data interface{}) {
+		err := templates.ExecuteTemplate(w, name, data)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
 	}
 
+	var stats []*Stat
+	var perfs []*Perf
+	searches := make(map[string]*Search)
 	// Histogram used for monitoring of search time
-	cacmH := expvar.NewHistogram("cacm", 50)
-	cs276H := expvar.NewHistogram("cs276", 50)
-
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		corpus := r.FormValue("corpus")
-		input := r.FormValue("search")
-		if len(corpus) == 0 || len(input) == 0 {
-			templates.ExecuteTemplate(w, "index", answer{})
-			return
-		}
-
-		searchType := r.FormValue("type")
-		weightFun := r.FormValue("weight")
-
-		var offset int
-		offset, _ = strconv.Atoi(r.FormValue("offset"))
-
-		var search *Search
-		var hist metrics.Histogram
-		a := answer{Query: input, Weight: weightFun}
-		if corpus == "cacm" {
-			search = cacm
-			hist = cacmH
-		} else if corpus == "cs276" {
-			search = cs276
-			hist = cs276H
-			a.CS276 = true
-		} else {
-			templates.ExecuteTemplate(w, "index", a)
-			return
-		}
-		now := time.Now()
-		if searchType == "boolean" {
-			a.Results = search.BooleanSearch(input)
-		} else if searchType == "vectorial" {
-			if weightFun == "norm" {
-				a.Results = search.VectorSearch(input, norm)
-			} else if weightFun == "half" {
-				a.Results = search.VectorSearch(input, half)
-			} else {
-				a.Results = search.VectorSearch(input, raw)
-			}
-			a.Vectorial = true
-		} else {
-			templates.ExecuteTemplate(w, "index", a)
-			return
+	hists := make(map[string]metrics.Histogram)
+	for _, search := range list {
+		stats = append(stats, &search.Stat)
+		perfs = append(perfs, &search.Perf)
+		searches[search.Corpus] = search
+		hists[search.Corpus] = expvar.NewHistogram(search.Corpus, 50)
+		if search.corpus != nil {
+			http.HandleFunc("/"+search.Corpus+"/", docPageHandler(render, search))
 		}
-		hist.Observe(float64(time.Since(now)))
-		a.Time = time.Since(now).String()
-		a.Size = len(a.Results)
-		if offset > 0 && len(a.Results) > offset {
-			a.Results = a.Results[offset:]
-			if offset > 0 {
-				a.Prev = fmt.Sprintf("/?search=%s&offset=%d&corpus=%s&type=%s",
-					input, max(offset-maxSize, 0), corpus, searchType)
-			}
-		} else {
-			offset = 0
-		}
-		if len(a.Results) > maxSize {
-			a.Results = a.Results[:maxSize]
-			a.Next = fmt.Sprintf("/?search=%s&offset=%d&corpus=%s&type=%s",
-				input, offset+maxSize, corpus, searchType)
+	}
+	lookup := func(corpus string) searcher {
+		search, ok := searches[corpus]
+		if !ok {
+			return nil
 		}
+		return search
+	}
+	http.HandleFunc("/", searchHandler(templates, lookup, hists))
 
-		templates.ExecuteTemplate(w, "index", a)
-	})
+	// json api, also used by coordinators when this server is a shard
+	http.HandleFunc("/api/search", apiSearchHandler(lookup))
+	http.HandleFunc("/api/stats", apiStatsHandler(searches))
 
 	http.HandleFunc("/stat", func(w http.ResponseWriter, r *http.Request) {
-		err := templates.ExecuteTemplate(w, "stat", stats)
-		if err != nil {
-			log.Fatal(err.Error())
-		}
+		render(w, "stat", stats)
 	})
 
 	http.HandleFunc("/perf", func(w http.ResponseWriter, r *http.Request) {
-		err := templates.ExecuteTemplate(w, "perf", perfs)
-		if err != nil {
-			log.Fatal(err.Error())
-		}
+		render(w, "perf", perfs)
 	})
 
-	http.HandleFunc("/cacm/", func(w http.ResponseWriter, r *http.Request) {
-		// len("/cacm/") = 5
-		id, err := strconv.Atoi(r.URL.Path[6:])
-		if err != nil {
-			http.NotFound(w, r)
-			return
-		}
-		doc, err := getCACMDoc(id)
-		if err != nil {
+	// the evaluation pages exist when a corpus served has judgments, see testCollection
+	http.HandleFunc("/qrels", func(w http.ResponseWriter, r *http.Request) {
+		if precall == nil {
 			http.NotFound(w, r)
 			return
 		}
-		err = templates.ExecuteTemplate(w, "cacm", doc)
-		if err != nil {
-			log.Fatal(err.Error())
-		}
+		render(w, "qrels", precall)
 	})
 
-	http.HandleFunc("/qrels", func(w http.ResponseWriter, r *http.Request) {
-		err := templates.ExecuteTemplate(w, "qrels", precall)
-		if err != nil {
-			log.Fatal(err.Error())
+	http.HandleFunc("/precall", func(w http.ResponseWriter, r *http.Request) {
+		if precall == nil {
+			http.NotFound(w, r)
+			return
 		}
+		render(w, "precall", precall)
 	})
 
-	http.HandleFunc("/precall", func(w http.ResponseWriter, r *http.Request) {
-		err := templates.ExecuteTemplate(w, "precall", precall)
-		if err != nil {
-			log.Fatal(err.Error())
+	http.HandleFunc("/precall.tsv", func(w http.ResponseWriter, r *http.Request) {
+		if precall == nil {
+			http.NotFound(w, r)
+			return
 		}
+		w.Header().Set("Content-Type", "text/tab-separated-values; charset=utf-8")
+		precall.WriteTSV(w)
 	})
 
 	http.HandleFunc("/archi", func(w http.ResponseWriter, r *http.Request) {
-		err := templates.ExecuteTemplate(w, "archi", nil)
-		if err != nil {
-			log.Fatal(err.Error())
-		}
+		render(w, "archi", nil)
 	})
 
 	// Static content
@@ -182,10 +140,7 @@ func serve(cacm, cs276 *Search, precall *PreCallCalculator) {
 	http.Handle("/graphs/", http.StripPrefix("/graphs/", fs))
 
 	http.HandleFunc("/percentile", func(w http.ResponseWriter, r *http.Request) {
-		err := templates.ExecuteTemplate(w, "percentile", nil)
-		if err != nil {
-			log.Fatal(err.Error())
-		}
+		render(w, "percentile", nil)
 	})
 
 	http.HandleFunc("/favicon.ico", func(w http.ResponseWriter, r *http.Request) {
@@ -197,7 +152,51 @@ func serve(cacm, cs276 *Search, precall *PreCallCalculator) {
 	})
 
 	log.Println("riw starting to serve traffic")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+}
+
+// searchHandler serves the search page
+// lookup returns the searcher for a corpus, hists the histograms monitoring search time
+func searchHandler(templates *template.Template, lookup func(string) searcher,
+	hists map[string]metrics.Histogram) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := parseQuery(r)
+		if len(q.Corpus) == 0 || len(q.Input) == 0 {
+			templates.ExecuteTemplate(w, "index", answer{Corpus: corpusNames()[0], Corpora: corpusNames()})
+			return
+		}
+		weightFun := r.FormValue("weight")
+
+		a := answer{Query: q.Input, Weight: weightFun, Sort: q.Sort, Corpus: q.Corpus, Corpora: corpusNames()}
+		search := lookup(q.Corpus)
+		if search == nil || (q.Type != "boolean" && q.Type != "vectorial") {
+			templates.ExecuteTemplate(w, "index", a)
+			return
+		}
+		a.Vectorial = q.Type == "vectorial"
+		q.Size = maxSize
+
+		now := time.Now()
+		res := search.query(q)
+		if q.Offset > 0 && res.Size <= q.Offset {
+			q.Offset = 0
+			res = search.query(q)
+		}
+		hists[q.Corpus].Observe(float64(time.Since(now)))
+		a.Time = time.Since(now).String()
+		a.Size = res.Size
+		a.Results = res.Results
+		a.Missing = res.Missing
+		if q.Offset > 0 {
+			a.Prev = fmt.Sprintf("/?search=%s&offset=%d&corpus=%s&type=%s&weight=%s&sort=%s",
+				url.QueryEscape(q.Input), max(q.Offset-maxSize, 0), q.Corpus, q.Type, weightFun, q.Sort)
+		}
+		if q.Offset+maxSize < res.Size {
+			a.Next = fmt.Sprintf("/?search=%s&offset=%d&corpus=%s&type=%s&weight=%s&sort=%s",
+				url.QueryEscape(q.Input), q.Offset+maxSize, q.Corpus, q.Type, weightFun, q.Sort)
+		}
+
+		templates.ExecuteTemplate(w, "index", a)
+	}
 }
 
 func max(a, b int) int {
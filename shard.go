@@ -0,0 +1,203 @@
+// Shard.go implements the partitioning of a corpus in shards and the api they serve
+// each shard is built and served by its own riw process, for example
+//
+//	rechercheInfoWeb -index -shards 2 -shard 0 -indexes indexes/0 -addr :8081
+//
+// documents keep the id they have in the whole corpus,
+// the ones outside of the shard are marked deleted and purged from the index
+// the coordinator (see coordinator.go) uses /api/stats to get global idf statistics
+// then /api/search to get the best results of each shard
+package main
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// shardCount is the number of shards a corpus is split in, shardIndex the one kept
+// partition is the way documents are split, "range" of ids or "hash" of external ids
+var shardCount, shardIndex int
+var partition string
+
+// apiQuery is a query as received by the api
+type apiQuery struct {
+	Corpus string
+	Input  string
+	// Type is either boolean or vectorial
+	Type   string
+	Weight weight
+	// Offset and Size define the page of results returned
+	Offset int
+	Size   int
+	// Stats are global statistics to use for idf, sent by a coordinator
+	Stats *apiStats
+	// Sort is "date" to order results from the most recent, by relevance otherwise
+	Sort string
+}
+
+// apiStats are the statistics needed to compute idf for a query
+type apiStats struct {
+	Documents int
+	// DF is the document frequency of each query term
+	DF map[string]int
+}
+
+// apiAnswer is the page of results returned by the api
+type apiAnswer struct {
+	// Size is the total number of results
+	Size    int
+	Results []Result
+	// Missing lists the shards which couldn't be queried
+	Missing []string `json:",omitempty"`
+}
+
+// searcher is a corpus that can be queried, either a local index or a set of shards
+type searcher interface {
+	query(q apiQuery) apiAnswer
+}
+
+// inShard returns wether a document belongs to the shard kept
+func inShard(id, size int, external string) bool {
+	if partition == "hash" {
+		h := fnv.New32a()
+		h.Write([]byte(external))
+		return int(h.Sum32()%uint32(shardCount)) == shardIndex
+	}
+	perShard := (size + shardCount - 1) / shardCount
+	return id/perShard == shardIndex
+}
+
+// keepShard removes the documents outside of the kept shard from a freshly built search
+func keepShard(search *Search) {
+	if shardCount <= 1 {
+		return
+	}
+	seg := search.Segments[0]
+	for i, external := range seg.Externals {
+		if !inShard(seg.First+i, len(seg.Titles), external) {
+			seg.Deleted.set(i)
+		}
+	}
+	// merging a single segment rewrites it without the deleted refs
+	search.Segments[0] = mergeSegments(seg.Name, []*Segment{seg})
+	search.Size = seg.live()
+	search.Stat.Documents = search.Size
+}
+
+// query runs a query on the local index
+func (s *Search) query(q apiQuery) apiAnswer {
+	var refs []Ref
+	vectorial := q.Type != "boolean"
+	if !vectorial {
+		refs = BooleanQuery(s, q.Input)
+	} else if q.Stats != nil {
+		refs = vectorQuery(s, q.Input, q.Weight, func(w string, df int) float64 {
+			// terms missing from the global statistics, as when a shard answered after the stats were gathered,
+			// use the local ones
+			if q.Stats.DF[w] == 0 || q.Stats.Documents == 0 {
+				return s.idf(df)
+			}
+			return math.Log(float64(q.Stats.Documents) / float64(q.Stats.DF[w]))
+		})
+	} else {
+		refs = VectorQuery(s, q.Input, q.Weight)
+	}
+	if q.Sort == "date" {
+		s.sortByDate(refs)
+	}
+
+	a := apiAnswer{Size: len(refs)}
+	if q.Offset < len(refs) {
+		refs = refs[q.Offset:]
+	} else {
+		refs = nil
+	}
+	if len(refs) > q.Size {
+		refs = refs[:q.Size]
+	}
+	a.Results = make([]Result, len(refs))
+	terms := highlightTerms(s, q.Input)
+	for i, ref := range refs {
+		a.Results[i] = s.result(ref.Id)
+		a.Results[i].Date = s.date(ref.Id)
+		a.Results[i].Snippet = s.snippet(ref.Id, terms)
+		if vectorial {
+			a.Results[i].Score = ref.Weights[q.Weight]
+		}
+	}
+	return a
+}
+
+// stats returns the local statistics for the terms of a query
+func (s *Search) stats(input string) apiStats {
+	stats := apiStats{Documents: s.Size, DF: make(map[string]int)}
+	for _, w := range queryTerms(s, input) {
+		stats.DF[w] = len(s.get(w))
+	}
+	return stats
+}
+
+// parseWeight returns the weight function from its name in a form
+func parseWeight(name string) weight {
+	switch name {
+	case "norm":
+		return norm
+	case "half":
+		return half
+	default:
+		return raw
+	}
+}
+
+// parseQuery reads a query from the form values of a request
+func parseQuery(r *http.Request) apiQuery {
+	q := apiQuery{
+		Corpus: r.FormValue("corpus"),
+		Input:  r.FormValue("search"),
+		Type:   r.FormValue("type"),
+		Weight: parseWeight(r.FormValue("weight")),
+		Size:   maxSize,
+		Sort:   r.FormValue("sort"),
+	}
+	q.Offset, _ = strconv.Atoi(r.FormValue("offset"))
+	if size, err := strconv.Atoi(r.FormValue("size")); err == nil {
+		q.Size = size
+	}
+	if stats := r.FormValue("stats"); stats != "" {
+		q.Stats = &apiStats{}
+		if err := json.Unmarshal([]byte(stats), q.Stats); err != nil {
+			q.Stats = nil
+		}
+	}
+	return q
+}
+
+// apiSearchHandler answers queries in json
+func apiSearchHandler(lookup func(corpus string) searcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := parseQuery(r)
+		search := lookup(q.Corpus)
+		if search == nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(search.query(q))
+	}
+}
+
+// apiStatsHandler returns the local statistics of a query terms in json
+func apiStatsHandler(searches map[string]*Search) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		search, ok := searches[r.FormValue("corpus")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(search.stats(r.FormValue("search")))
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// useShards splits the corpora in count shards until the test ends
+func useShards(t *testing.T, count int, by string) {
+	oldCount, oldIndex, oldPartition := shardCount, shardIndex, partition
+	shardCount, partition = count, by
+	t.Cleanup(func() { shardCount, shardIndex, partition = oldCount, oldIndex, oldPartition })
+}
+
+func TestInShard(t *testing.T) {
+	for _, by := range []string{"range", "hash"} {
+		useShards(t, 3, by)
+		sizes := make([]int, shardCount)
+		for id := 0; id < 100; id++ {
+			var in []int
+			for shardIndex = 0; shardIndex < shardCount; shardIndex++ {
+				if inShard(id, 100, strconv.Itoa(id)) {
+					in = append(in, shardIndex)
+				}
+			}
+			if len(in) != 1 {
+				t.Fatalf("%s: document %d in shards %v", by, id, in)
+			}
+			sizes[in[0]]++
+		}
+		for i, size := range sizes {
+			if size == 0 {
+				t.Errorf("%s: shard %d is empty", by, i)
+			}
+		}
+	}
+	// ranges are contiguous, the last shard being the smallest
+	useShards(t, 3, "range")
+	shardIndex = 1
+	if inShard(33, 100, "") || !inShard(34, 100, "") || !inShard(67, 100, "") || inShard(68, 100, "") {
+		t.Error("Incorrect range of shard 1")
+	}
+}
+
+func TestShardQuery(t *testing.T) {
+	useTempIndexDir(t)
+	search := ParseCACM(strings.NewReader(testCACM+testCACMAddition), map[string]bool{})
+	q := apiQuery{Corpus: "cacm", Input: "compiler tapes", Weight: raw, Size: 10}
+	local := search.query(q)
+	// terms without global statistics fall back to the local ones
+	q.Stats = &apiStats{Documents: 8, DF: map[string]int{}}
+	a := search.query(q)
+	if len(a.Results) != len(local.Results) || len(a.Results) == 0 {
+		t.Fatalf("%d results instead of %d", len(a.Results), len(local.Results))
+	}
+	for i, result := range a.Results {
+		if math.IsInf(result.Score, 0) || math.IsNaN(result.Score) || result.Score != local.Results[i].Score {
+			t.Errorf("Result %d scored %f instead of %f", result.Id, result.Score, local.Results[i].Score)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+// Significance.go implements the tests used to compare two retrieval configurations
+// both are paired tests on the per query values of a measure,
+// they return the two sided p-value of the null hypothesis "the configurations are equivalent"
+package main
+
+import (
+	"math"
+	"math/rand"
+)
+
+// pairedTTest returns the p-value of Student's paired t-test
+func pairedTTest(a, b []float64) float64 {
+	n := float64(len(a))
+	if len(a) < 2 {
+		return 1
+	}
+	var mean float64
+	for i := range a {
+		mean += a[i] - b[i]
+	}
+	mean /= n
+	var variance float64
+	for i := range a {
+		d := a[i] - b[i] - mean
+		variance += d * d
+	}
+	variance /= n - 1
+	if variance == 0 {
+		if mean == 0 {
+			return 1
+		}
+		return 0
+	}
+	t := mean / math.Sqrt(variance/n)
+	df := n - 1
+	// the two sided tail of Student's distribution, from the incomplete beta function
+	return incompleteBeta(df/2, 0.5, df/(df+t*t))
+}
+
+// randomizationTest returns the p-value of Fisher's paired randomization test
+// the sign of each difference is flipped at random in each of the trials
+// the random source is seeded so results are reproducible
+func randomizationTest(a, b []float64, trials int) float64 {
+	diffs := make([]float64, len(a))
+	var observed float64
+	for i := range a {
+		diffs[i] = a[i] - b[i]
+		observed += diffs[i]
+	}
+	observed = math.Abs(observed)
+	r := rand.New(rand.NewSource(1))
+	// the observed assignment counts as one of the trials
+	extreme := 1
+	for t := 0; t < trials; t++ {
+		var sum float64
+		for _, d := range diffs {
+			if r.Intn(2) == 0 {
+				sum += d
+			} else {
+				sum -= d
+			}
+		}
+		// small epsilon to count equal sums despite rounding
+		if math.Abs(sum) >= observed-1e-12 {
+			extreme++
+		}
+	}
+	return float64(extreme) / float64(trials+1)
+}
+
+// incompleteBeta returns the regularized incomplete beta function I_x(a, b)
+// adapted from Numerical Recipes, it uses a continued fraction
+func incompleteBeta(a, b, x float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	if x >= 1 {
+		return 1
+	}
+	la, _ := math.Lgamma(a)
+	lb, _ := math.Lgamma(b)
+	lab, _ := math.Lgamma(a + b)
+	front := math.Exp(lab - la - lb + a*math.Log(x) + b*math.Log(1-x))
+	// the continued fraction converges quickly for x < (a+1)/(a+b+2)
+	if x < (a+1)/(a+b+2) {
+		return front * betaFraction(a, b, x) / a
+	}
+	return 1 - front*betaFraction(b, a, 1-x)/b
+}
+
+// betaFraction evaluates the continued fraction of the incomplete beta function
+// using the modified Lentz's method
+func betaFraction(a, b, x float64) float64 {
+	const (
+		maxIterations = 200
+		epsilon       = 1e-14
+		tiny          = 1e-300
+	)
+	c := 1.0
+	d := 1 - (a+b)*x/(a+1)
+	if math.Abs(d) < tiny {
+		d = tiny
+	}
+	d = 1 / d
+	h := d
+	for m := 1; m <= maxIterations; m++ {
+		fm := float64(m)
+		// even step
+		num := fm * (b - fm) * x / ((a + 2*fm - 1) * (a + 2*fm))
+		d = 1 + num*d
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = 1 + num/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		h *= d * c
+		// odd step
+		num = -(a + fm) * (a + b + fm) * x / ((a + 2*fm) * (a + 2*fm + 1))
+		d = 1 + num*d
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = 1 + num/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		delta := d * c
+		h *= delta
+		if math.Abs(delta-1) < epsilon {
+			break
+		}
+	}
+	return h
+}
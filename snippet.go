@@ -0,0 +1,214 @@
+// Snippet.go implements the query biased snippets of the results
+// the text of a result is read from the document store and split in words
+// words are analyzed as when indexing (stemming) to find the ones matching the query terms
+// the passages of snippetWords words holding the most query terms are kept, in the order of the text
+// a snippet is a list of fragments so templates can highlight matches without writing html
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	// snippetWords is the number of words of a passage
+	snippetWords = 30
+	// snippetPassages is the maximum number of passages of a snippet
+	snippetPassages = 2
+)
+
+// Fragment is a part of a snippet, Match is true for the words matching the query
+type Fragment struct {
+	Text  string
+	Match bool `json:",omitempty"`
+}
+
+// word is a word of a text with its position
+type word struct {
+	start, end int
+	// term is the query term matched by the word, empty if none
+	term string
+}
+
+// cacmText returns the text of a stored cacm document used for snippets, its abstract and keywords
+func cacmText(text []byte) string {
+	buf := bufio.NewReader(bytes.NewReader(text))
+	// skip the .I line
+	buf.ReadString('\n')
+	doc, _ := ParseCACMDoc(buf)
+	return doc.W + doc.K
+}
+
+// highlightTerms returns the terms of a query that can be matched in the text of documents
+// field terms are matched by their value, boolean operators and years are ignored
+func highlightTerms(s *Search, input string) map[string]bool {
+	terms := make(map[string]bool)
+	for _, term := range queryTerms(s, input) {
+		switch strings.ToUpper(term) {
+		case "AND", "OR", "NOT":
+			continue
+		}
+		for _, prefix := range []string{titlePrefix, abstractPrefix, keywordPrefix, headingPrefix} {
+			term = strings.TrimPrefix(term, prefix)
+		}
+		if !strings.HasPrefix(term, yearPrefix) {
+			terms[term] = true
+		}
+	}
+	return terms
+}
+
+// splitWords returns the words of a text, with the query term they match
+// analyze is the analysis of words of the corpus, see Corpus.Analyze
+func splitWords(text string, terms map[string]bool, analyze func(string) string) []word {
+	var words []word
+	start := -1
+	for i, r := range text + " " {
+		if !splitter(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start < 0 {
+			continue
+		}
+		w := word{start: start, end: i}
+		lit := text[start:i]
+		// the same analysis as the scanner and authorTerm
+		if term := analyze(lit); terms[term] {
+			w.term = term
+		} else if term := authorTerm(lit); terms[term] {
+			w.term = term
+		}
+		words = append(words, w)
+		start = -1
+	}
+	return words
+}
+
+// passageScore returns the score of the passage of words starting at i
+// the number of distinct terms matched is what matters, then the number of matches
+func passageScore(words []word, i int) int {
+	seen := make(map[string]bool)
+	var matches int
+	for _, w := range words[i:min(i+snippetWords, len(words))] {
+		if w.term != "" {
+			seen[w.term] = true
+			matches++
+		}
+	}
+	return len(seen)*snippetWords + matches
+}
+
+// snippet returns the best passages of text for the query terms
+// the beginning of the text is used when no word matches
+func snippet(text string, terms map[string]bool, analyze func(string) string) []Fragment {
+	words := splitWords(text, terms, analyze)
+	if len(words) == 0 {
+		return nil
+	}
+	// passages are chosen greedily, each one can't overlap the ones already chosen
+	var starts []int
+	taken := make([]bool, len(words))
+	for p := 0; p < snippetPassages; p++ {
+		best, bestScore := -1, 0
+		for i := range words {
+			if taken[i] || taken[min(i+snippetWords, len(words))-1] {
+				continue
+			}
+			if score := passageScore(words, i); score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+		if best < 0 {
+			break
+		}
+		for i := best; i < min(best+snippetWords, len(words)); i++ {
+			taken[i] = true
+		}
+		starts = append(starts, best)
+	}
+	if len(starts) == 0 {
+		starts = []int{0}
+	}
+	sort.Ints(starts)
+
+	var fragments []Fragment
+	for _, start := range starts {
+		end := min(start+snippetWords, len(words))
+		// passages following another one are already separated by its ending " …"
+		if start > 0 && len(fragments) == 0 {
+			fragments = appendText(fragments, "… ")
+		}
+		last := words[start].start
+		for _, w := range words[start:end] {
+			if w.term == "" {
+				continue
+			}
+			fragments = appendText(fragments, text[last:w.start])
+			fragments = append(fragments, Fragment{Text: text[w.start:w.end], Match: true})
+			last = w.end
+		}
+		fragments = appendText(fragments, text[last:words[end-1].end])
+		if end < len(words) {
+			fragments = appendText(fragments, " …")
+		}
+		fragments = appendText(fragments, " ")
+	}
+	fragments[len(fragments)-1].Text = strings.TrimRightFunc(fragments[len(fragments)-1].Text, unicode.IsSpace)
+	return fragments
+}
+
+// appendText adds text to the fragments, merged with the last one if it isn't a match
+// spaces are collapsed as the text comes from files with line breaks
+func appendText(fragments []Fragment, text string) []Fragment {
+	text = collapseSpaces(text)
+	if text == "" {
+		return fragments
+	}
+	if l := len(fragments); l > 0 && !fragments[l-1].Match {
+		text = collapseSpaces(fragments[l-1].Text + text)
+		fragments[l-1].Text = text
+		return fragments
+	}
+	return append(fragments, Fragment{Text: text})
+}
+
+// collapseSpaces replaces runs of spaces by a single space
+func collapseSpaces(text string) string {
+	var buf strings.Builder
+	space := false
+	for _, r := range text {
+		if unicode.IsSpace(r) {
+			if !space {
+				buf.WriteRune(' ')
+			}
+			space = true
+			continue
+		}
+		space = false
+		buf.WriteRune(r)
+	}
+	return buf.String()
+}
+
+// min returns the smallest of two ints
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// snippet returns the snippet of a document for the query terms, nil if its text isn't stored
+func (s *Search) snippet(id int, terms map[string]bool) []Fragment {
+	text, err := s.document(id)
+	if err != nil {
+		return nil
+	}
+	return snippet(s.text(text), terms, s.analyze)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSnippet(t *testing.T) {
+	text := "Sorting records on tapes.\n" + strings.Repeat("filler ", 40) +
+		"A compiler for an algebraic language, the compiler is fast.\n" + strings.Repeat("filler ", 40)
+	fragments := snippet(text, map[string]bool{stem("compiler"): true, stem("language"): true}, stem)
+
+	var matches []string
+	var rendered string
+	for _, f := range fragments {
+		if f.Match {
+			matches = append(matches, f.Text)
+		}
+		rendered += f.Text
+	}
+	if strings.Join(matches, ",") != "compiler,language,compiler" {
+		t.Errorf("Matches are %v", matches)
+	}
+	if !strings.HasPrefix(rendered, "… ") || !strings.HasSuffix(rendered, " …") || strings.Contains(rendered, "\n") {
+		t.Errorf("Snippet rendered as %q", rendered)
+	}
+	if strings.Contains(rendered, "Sorting") {
+		t.Errorf("Snippet doesn't start at the best passage: %q", rendered)
+	}
+
+	// without matches the beginning of the text is used
+	fragments = snippet(text, map[string]bool{}, stem)
+	if len(fragments) != 1 || !strings.HasPrefix(fragments[0].Text, "Sorting records on tapes. filler") {
+		t.Errorf("Snippet without matches is %v", fragments)
+	}
+}
@@ -0,0 +1,370 @@
+// Spimi.go implements indexing within a memory budget, in the way of SPIMI (single-pass in-memory indexing)
+// documents are added to the trie as usual, but once its estimated size reaches the budget set by -memory
+// the trie is written to disk as a run, the sorted list of its terms and postings, and emptied
+// when the scan is done the runs are merged, k-way, into the final .index file which is written
+// node by node from the sorted terms, see trieWriter, then loaded like any index
+//
+// documents take their ids while the trie can't be written, see Root.flushing, so all the ids of a run
+// are lower than the ones of the next runs and the postings of a term are concatenated in run order
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/golang/snappy"
+)
+
+// memoryBudget is the size in megabytes over which tries being built are written to runs, 0 for no limit
+var memoryBudget int
+
+const (
+	// postingSize and nodeSize are the estimated sizes of a ref and of a node in a trie
+	// slices of refs grow by doubling so refs are counted twice, nodes have a short radix and a son pointer
+	postingSize = 2 * int64(unsafe.Sizeof(Ref{}))
+	nodeSize    = int64(unsafe.Sizeof(Node{})+unsafe.Sizeof("")+unsafe.Sizeof(&Node{})) + 4
+	// mergeFanIn is the maximum number of runs merged at once, more are first merged in bigger runs
+	mergeFanIn = 64
+)
+
+// spill holds the runs written by a trie
+type spill struct {
+	name   string
+	budget int64
+	// size is the estimated size of the trie, updated atomically
+	size int64
+	// mu is held by the worker writing a run
+	mu   sync.Mutex
+	runs []string
+	// created is the number of run files created, it numbers them
+	created int
+}
+
+// spillTo makes the trie write runs named after name each time it reaches budget bytes
+// the trie is kept in memory when budget isn't positive
+func (r *Root) spillTo(name string, budget int64) {
+	if budget > 0 {
+		r.spill = &spill{name: name, budget: budget}
+	}
+}
+
+// grow adds the size of a document to the estimated size of the trie, writing it to a run when it's too big
+func (s *spill) grow(r *Root, postings, nodes int) {
+	if atomic.AddInt64(&s.size, int64(postings)*postingSize+int64(nodes)*nodeSize) < s.budget {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// another worker may have written the run meanwhile
+	if atomic.LoadInt64(&s.size) < s.budget {
+		return
+	}
+	r.flushing.Lock()
+	defer r.flushing.Unlock()
+	s.writeRun(r)
+}
+
+// writeRun writes the terms of the trie in a new run, then empties the trie
+func (s *spill) writeRun(r *Root) {
+	w := s.createRun()
+	r.walk(w.add)
+	w.close()
+	s.runs = append(s.runs, w.name)
+	r.Node = &Node{}
+	atomic.StoreInt64(&s.size, 0)
+	log.Printf("%s run %d written\n", s.name, len(s.runs))
+}
+
+// runWriter writes a run, a snappy compressed list of entries, each one a term and its refs
+// a term is written as a string slice of one element, the empty slice ends the run
+type runWriter struct {
+	name string
+	file *os.File
+	w    *snappy.Writer
+	buf  []byte
+}
+
+// createRun creates a new run file of the spill
+func (s *spill) createRun() *runWriter {
+	name := indexFile(fmt.Sprintf("%s.run%d", s.name, s.created))
+	s.created++
+	file, err := os.Create(name)
+	if err != nil {
+		panic(err)
+	}
+	return &runWriter{name: name, file: file, w: snappy.NewBufferedWriter(file), buf: make([]byte, 9)}
+}
+
+func (w *runWriter) add(term string, refs []Ref) {
+	encodeStringSlice(w.w, []string{term}, w.buf)
+	encodeRefs(w.w, refs, w.buf)
+}
+
+func (w *runWriter) close() {
+	encodeStringSlice(w.w, nil, w.buf)
+	if err := w.w.Close(); err != nil {
+		panic(err)
+	}
+	if err := w.file.Close(); err != nil {
+		panic(err)
+	}
+}
+
+// runReader reads the entries of a run, term and refs are the ones of the current entry
+type runReader struct {
+	// order is the position of the run, the refs of earlier runs come first
+	order int
+	file  *os.File
+	r     io.Reader
+	buf   []byte
+	term  string
+	refs  []Ref
+	done  bool
+}
+
+func openRun(name string, order int) *runReader {
+	file, err := os.Open(name)
+	if err != nil {
+		panic(err)
+	}
+	rr := &runReader{order: order, file: file, r: snappy.NewReader(file), buf: make([]byte, 9)}
+	rr.next()
+	return rr
+}
+
+// next reads the next entry, done is set at the end of the run
+func (rr *runReader) next() {
+	terms := decodeStringSlice(rr.r, rr.buf)
+	if len(terms) == 0 {
+		rr.done = true
+		rr.file.Close()
+		return
+	}
+	rr.term = terms[0]
+	rr.refs = decodeRefs(rr.r, rr.buf)
+}
+
+// runHeap orders the runs being merged by their current term
+type runHeap []*runReader
+
+func (h runHeap) Len() int { return len(h) }
+func (h runHeap) Less(i, j int) bool {
+	if h[i].term != h[j].term {
+		return h[i].term < h[j].term
+	}
+	return h[i].order < h[j].order
+}
+func (h runHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x interface{}) { *h = append(*h, x.(*runReader)) }
+func (h *runHeap) Pop() interface{} {
+	old := *h
+	rr := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return rr
+}
+
+// mergeRunFiles merges runs, the postings of each term are given to add in increasing term order
+// the run files are removed once merged
+func mergeRunFiles(runs []string, add func(term string, refs []Ref)) {
+	h := make(runHeap, 0, len(runs))
+	for i, name := range runs {
+		if rr := openRun(name, i); !rr.done {
+			h = append(h, rr)
+		}
+	}
+	heap.Init(&h)
+	var refs []Ref
+	for len(h) > 0 {
+		term := h[0].term
+		refs = refs[:0]
+		for len(h) > 0 && h[0].term == term {
+			rr := h[0]
+			refs = append(refs, rr.refs...)
+			rr.next()
+			if rr.done {
+				heap.Pop(&h)
+			} else {
+				heap.Fix(&h, 0)
+			}
+		}
+		add(term, refs)
+	}
+	for _, name := range runs {
+		if err := os.Remove(name); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// mergeRuns merges the runs written by the trie and the documents it still holds in its .index file
+// the trie is then loaded from it, it returns the number of runs merged, 0 if the trie never was written
+func (r *Root) mergeRuns() int {
+	s := r.spill
+	r.spill = nil
+	if s == nil || len(s.runs) == 0 {
+		return 0
+	}
+	s.writeRun(r)
+	count := len(s.runs)
+	// runs are merged by mergeFanIn until they can all be merged at once
+	for len(s.runs) > mergeFanIn {
+		var merged []string
+		for len(s.runs) > 0 {
+			n := mergeFanIn
+			if n > len(s.runs) {
+				n = len(s.runs)
+			}
+			group := s.runs[:n]
+			s.runs = s.runs[n:]
+			w := s.createRun()
+			mergeRunFiles(group, w.add)
+			w.close()
+			merged = append(merged, w.name)
+		}
+		s.runs = merged
+	}
+	tw := newTrieWriter()
+	mergeRunFiles(s.runs, tw.add)
+	tw.write(s.name, r.count)
+	r.Node = UnserializeTrie(s.name).Node
+	return count
+}
+
+// discard drops the postings of a build given up, the runs already written are removed
+func (r *Root) discard() {
+	r.locals = nil
+	if r.spill == nil {
+		return
+	}
+	for _, name := range r.spill.runs {
+		if err := os.Remove(name); err != nil {
+			log.Println(err)
+		}
+	}
+	r.spill = nil
+}
+
+// trieWriter writes a trie in the format of Node.Encode from terms added in increasing order
+// the nodes on the path of the last term are open, a node is encoded once no later term can reach it
+// and its encoding appended to the sons of its parent; the sons of the root are kept in a temporary file
+// so at most the encoding of one son of the root is in memory
+type trieWriter struct {
+	last string
+	open []*openNode
+	// rootSons are the encoded sons of the root
+	rootSons *os.File
+	sons     *bufio.Writer
+	buf      []byte
+}
+
+// openNode is a node whose sons aren't all known yet
+type openNode struct {
+	// depth is the length of the word leading to the node
+	depth int
+	refs  []Ref
+	radix []string
+	sons  bytes.Buffer
+}
+
+func newTrieWriter() *trieWriter {
+	file, err := ioutil.TempFile(indexDir, "sons")
+	if err != nil {
+		panic(err)
+	}
+	return &trieWriter{
+		open:     []*openNode{{}},
+		rootSons: file,
+		sons:     bufio.NewWriter(file),
+		buf:      make([]byte, 9),
+	}
+}
+
+// add adds a term and its refs, terms must be added in increasing order
+func (t *trieWriter) add(term string, refs []Ref) {
+	depth := 0
+	for depth < len(t.last) && depth < len(term) && t.last[depth] == term[depth] {
+		depth++
+	}
+	t.closeTo(depth)
+	top := t.open[len(t.open)-1]
+	// refs are copied as merged refs are reused
+	refs = append([]Ref(nil), refs...)
+	if top.depth == len(term) {
+		// only the empty term ends at an open node, the root
+		top.refs = refs
+	} else {
+		t.open = append(t.open, &openNode{depth: len(term), refs: refs})
+	}
+	t.last = term
+}
+
+// closeTo encodes the open nodes deeper than depth, the last term and the next one split at depth
+// so a node is inserted there if there is none
+func (t *trieWriter) closeTo(depth int) {
+	for {
+		n := t.open[len(t.open)-1]
+		if n.depth <= depth {
+			return
+		}
+		t.open = t.open[:len(t.open)-1]
+		parent := t.open[len(t.open)-1]
+		if parent.depth < depth {
+			parent = &openNode{depth: depth}
+			t.open = append(t.open, parent)
+		}
+		parent.radix = append(parent.radix, t.last[parent.depth:n.depth])
+		if parent.depth == 0 {
+			t.encode(t.sons, n)
+		} else {
+			t.encode(&parent.sons, n)
+		}
+	}
+}
+
+// encode writes a node closed, as Node.Encode
+func (t *trieWriter) encode(w io.Writer, n *openNode) {
+	encodeRefs(w, n.refs, t.buf)
+	encodeStringSlice(w, n.radix, t.buf)
+	if _, err := w.Write(n.sons.Bytes()); err != nil {
+		panic(err)
+	}
+}
+
+// write closes all nodes and writes the index file of name, as Root.Serialize
+func (t *trieWriter) write(name string, count int) {
+	t.closeTo(0)
+	defer os.Remove(t.rootSons.Name())
+	defer t.rootSons.Close()
+	if err := t.sons.Flush(); err != nil {
+		panic(err)
+	}
+	if _, err := t.rootSons.Seek(0, io.SeekStart); err != nil {
+		panic(err)
+	}
+	index, err := createIndexFile(name + ".index")
+	if err != nil {
+		panic(err)
+	}
+	defer index.Close()
+	buffered := snappy.NewBufferedWriter(index)
+	root := t.open[0]
+	encodeUInt(buffered, uint(count), t.buf)
+	encodeRefs(buffered, root.refs, t.buf)
+	encodeStringSlice(buffered, root.radix, t.buf)
+	if _, err := io.Copy(buffered, t.rootSons); err != nil {
+		panic(err)
+	}
+	if err := buffered.Close(); err != nil {
+		panic(err)
+	}
+	index.commit()
+}
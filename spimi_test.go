@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSpimi(t *testing.T) {
+	useTempIndexDir(t)
+	dir := writeTestCS276(t)
+	defer os.RemoveAll(dir)
+	corpus, _ := makeCorpus("testspimi", "cs276", dir)
+	memory := ParseCorpus(context.Background(), corpus, map[string]bool{})
+	memory.Segments[0].Index.Serialize("testspimi")
+	want, _ := ioutil.ReadFile(indexFile("testspimi.index"))
+	// so the index compared is the one merged from the runs
+	os.Remove(indexFile("testspimi.index"))
+
+	// a budget of a few documents, so the runs are more than merged at once
+	trie := NewTrie()
+	trie.spillTo("testspimi", 300)
+	search := emptySearch("testspimi", map[string]bool{})
+	search.corpus = corpus
+	search.Segments = []*Segment{newSegment(search.Corpus, trie)}
+	c := make(chan metadata, 100)
+	go NewCS276Scanner(dir, trie).Scan(context.Background(), c)
+	buildSearchFromScanner(context.Background(), search, c, startProgress(search.Corpus, 0))
+	if search.Perf.Runs <= mergeFanIn || search.Size != 120 {
+		t.Fatalf("%d runs written for %d documents", search.Perf.Runs, search.Size)
+	}
+	got, _ := ioutil.ReadFile(indexFile("testspimi.index"))
+	if len(want) == 0 || !bytes.Equal(want, got) {
+		t.Error("The merged index differs from the one built in memory")
+	}
+	if left, _ := filepath.Glob(indexFile("testspimi.run*")); len(left) > 0 {
+		t.Errorf("Runs left: %v", left)
+	}
+	refs := trie.get(stem("compiler1"))
+	if len(refs) != 40 || refs[0].Id != 40 {
+		t.Errorf("Incorrect refs after merge: %v", refs)
+	}
+	if search.Stat.Vocabulary != memory.Stat.Vocabulary {
+		t.Errorf("Vocabulary is %d instead of %d", search.Stat.Vocabulary, memory.Stat.Vocabulary)
+	}
+}
@@ -3,6 +3,8 @@ package main
 
 import "math"
 
+// Stat are the statistics of an index, Tokens and Vocabulary count the text indexed
+// including the documents deleted since
 type Stat struct {
 	Name       string
 	Documents  int
@@ -0,0 +1,139 @@
+// Store.go implements the document store of segments
+// the text of each document is compressed with snappy and written in the .docs file of its segment
+// the .offsets file holds the position of each document in it, so a document is read with a single ReadAt
+// documents are kept in memory until the segment is serialized
+package main
+
+import (
+	"encoding/gob"
+	"fmt"
+	"os"
+
+	"github.com/golang/snappy"
+)
+
+// addDocText stores the text of the document id in the segment
+func (seg *Segment) addDocText(id int, text []byte) {
+	for len(seg.Docs) <= id-seg.First {
+		seg.Docs = append(seg.Docs, nil)
+	}
+	seg.Docs[id-seg.First] = snappy.Encode(nil, text)
+}
+
+// storedDoc returns the compressed text of the document id
+func (seg *Segment) storedDoc(id int) ([]byte, error) {
+	i := id - seg.First
+	if seg.Docs != nil {
+		if i >= len(seg.Docs) || seg.Docs[i] == nil {
+			return nil, fmt.Errorf("segment %s has no text for document %d", seg.Name, id)
+		}
+		return seg.Docs[i], nil
+	}
+	if i+1 >= len(seg.Offsets) {
+		return nil, fmt.Errorf("segment %s has no document store", seg.Name)
+	}
+	docs, err := os.Open(indexFile(seg.Name + ".docs"))
+	if err != nil {
+		return nil, err
+	}
+	defer docs.Close()
+	compressed := make([]byte, seg.Offsets[i+1]-seg.Offsets[i])
+	_, err = docs.ReadAt(compressed, seg.Offsets[i])
+	return compressed, err
+}
+
+// document returns the text of the document id
+func (seg *Segment) document(id int) ([]byte, error) {
+	compressed, err := seg.storedDoc(id)
+	if err != nil {
+		return nil, err
+	}
+	return snappy.Decode(nil, compressed)
+}
+
+// document returns the text of a document, as it was indexed
+// the segment files are kept while they are read, even if the segment is merged meanwhile
+func (s *Search) document(id int) ([]byte, error) {
+	s.mu.RLock()
+	segs := s.Segments
+	i := findSegment(segs, id)
+	if id < 0 || i == len(segs) || segs[i].isDeleted(id) {
+		s.mu.RUnlock()
+		return nil, fmt.Errorf("%s has no document %d", s.Corpus, id)
+	}
+	seg := segs[i]
+	seg.acquire()
+	s.mu.RUnlock()
+	defer seg.release()
+	return seg.document(id)
+}
+
+// serializeStore writes the documents kept in memory and their offsets
+// afterwards documents are read from the file
+func (seg *Segment) serializeStore() {
+	if seg.Docs == nil {
+		return
+	}
+	docs, err := createIndexFile(seg.Name + ".docs")
+	if err != nil {
+		panic(err)
+	}
+	defer docs.Close()
+	offsets := make([]int64, 1, len(seg.Titles)+1)
+	for id := seg.First; id < seg.First+len(seg.Titles); id++ {
+		var compressed []byte
+		if id-seg.First < len(seg.Docs) {
+			compressed = seg.Docs[id-seg.First]
+		}
+		n, err := docs.Write(compressed)
+		if err != nil {
+			panic(err)
+		}
+		offsets = append(offsets, offsets[len(offsets)-1]+int64(n))
+	}
+	docs.commit()
+
+	file, err := createIndexFile(seg.Name + ".offsets")
+	if err != nil {
+		panic(err)
+	}
+	defer file.Close()
+	en := gob.NewEncoder(file)
+	err = en.Encode(offsets)
+	if err != nil {
+		panic(err)
+	}
+	file.commit()
+	seg.Offsets = offsets
+	seg.Docs = nil
+}
+
+// unserializeStore loads the offsets of the documents
+// segments saved before the store existed have none
+func (seg *Segment) unserializeStore() {
+	file, err := os.Open(indexFile(seg.Name + ".offsets"))
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	en := gob.NewDecoder(file)
+	err = en.Decode(&seg.Offsets)
+	if err != nil {
+		panic(err)
+	}
+	file.Close()
+}
+
+// hasStore returns wether the segment stores the text of its documents
+func (seg *Segment) hasStore() bool {
+	return seg.Docs != nil || seg.Offsets != nil
+}
+
+// copyStore adds the documents of from to the segment, without decompressing them
+// documents without text, e.g from segments saved before the store existed, stay empty
+func (seg *Segment) copyStore(from *Segment) {
+	for id := from.First; id < from.First+len(from.Titles); id++ {
+		compressed, _ := from.storedDoc(id)
+		seg.Docs = append(seg.Docs, compressed)
+	}
+}
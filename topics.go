@@ -0,0 +1,299 @@
+// Topics.go reads test collections topics and relevance judgments, and writes runs
+// two layouts are supported for each file:
+// topics are either CACM query.text (.I/.W blocks) or TREC topics (<top><num><title><desc>)
+// qrels are either CACM "qid docno 0 0" lines or TREC "qid iteration docno grade" lines
+// runs are written in the TREC format so they can be compared with trec_eval or other systems
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// runDepth is the number of documents per topic written in a run
+const runDepth = 1000
+
+// Topic is a query of a test collection
+type Topic struct {
+	// Id is the topic identifier, as used in the qrels
+	Id   string
+	Text string
+	// Boolean is the topic as a boolean query
+	Boolean string
+	// Authors are the authors whose articles are wanted, as written in CACM "Surname, Initials"
+	Authors []string
+	// Notes are free comments on the topic, not used for search
+	Notes string
+}
+
+// ReadTopics reads a topics file, in the CACM or TREC layout
+func ReadTopics(file string) []Topic {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		panic(err)
+	}
+	if bytes.Contains(content, []byte("<top>")) {
+		return readTRECTopics(bytes.NewReader(content))
+	}
+	return readCACMTopics(bytes.NewReader(content))
+}
+
+// readCACMTopics reads the .I, .W, .A and .N blocks of a CACM query file
+func readCACMTopics(r io.Reader) []Topic {
+	var topics []Topic
+	// block is the block being read
+	var block string
+	var text, notes bytes.Buffer
+	// end completes the last topic read
+	end := func() {
+		if len(topics) > 0 {
+			topics[len(topics)-1].Text = text.String()
+			topics[len(topics)-1].Notes = strings.TrimSpace(notes.String())
+		}
+		text.Reset()
+		notes.Reset()
+	}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		ln := scanner.Text()
+		switch {
+		case strings.HasPrefix(ln, ".I"):
+			end()
+			topics = append(topics, Topic{Id: normalizeId(ln[2:])})
+		// Seems to suffice for all block indicator
+		case strings.HasPrefix(ln, "."):
+			block = ln
+		case len(topics) == 0:
+		case block == ".W":
+			text.WriteString(ln)
+			text.WriteString(" ")
+		case block == ".A" && strings.TrimSpace(ln) != "":
+			topic := &topics[len(topics)-1]
+			topic.Authors = append(topic.Authors, strings.TrimSpace(ln))
+		case block == ".N":
+			notes.WriteString(strings.TrimSpace(ln))
+			notes.WriteString(" ")
+		}
+	}
+	end()
+	return topics
+}
+
+// readTRECTopics reads a TREC topic file, the query is the title followed by the description
+// the other fields (narrative, domain...) are ignored
+func readTRECTopics(r io.Reader) []Topic {
+	var topics []Topic
+	var num, title, desc bytes.Buffer
+	// field is the buffer of the field being read, nil for ignored ones
+	var field *bytes.Buffer
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		ln := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(ln, "<") && !strings.HasPrefix(ln, "</") {
+			end := strings.Index(ln, ">")
+			if end < 0 {
+				continue
+			}
+			switch ln[1:end] {
+			case "top":
+				num.Reset()
+				title.Reset()
+				desc.Reset()
+				field = nil
+			case "num":
+				field = &num
+			case "title":
+				field = &title
+			case "desc":
+				field = &desc
+			default:
+				field = nil
+			}
+			ln = ln[end+1:]
+		}
+		if strings.HasPrefix(ln, "</top>") {
+			topics = append(topics, Topic{
+				Id:   normalizeId(strings.TrimPrefix(strings.TrimSpace(num.String()), "Number:")),
+				Text: strings.Join(strings.Fields(title.String()+" "+desc.String()), " "),
+			})
+			field = nil
+			continue
+		}
+		// some collections close the fields, e.g <title> text </title>
+		if i := strings.Index(ln, "</"); i >= 0 {
+			ln = ln[:i]
+		}
+		if field == nil {
+			continue
+		}
+		for _, label := range []string{"Number:", "Topic:", "Description:"} {
+			ln = strings.TrimPrefix(strings.TrimSpace(ln), label)
+		}
+		field.WriteString(ln)
+		field.WriteString(" ")
+	}
+	return topics
+}
+
+// booleanFile returns the name of the boolean topics stored alongside a topics file
+// e.g data/CACM/query.bool for data/CACM/query.text
+func booleanFile(topics string) string {
+	return strings.TrimSuffix(topics, path.Ext(topics)) + ".bool"
+}
+
+// addBooleanTopics sets the boolean version of topics
+// hand-written queries are read from file, as "id query" lines, # starting comments
+// the others are converted automatically, if file doesn't exist all of them are
+func addBooleanTopics(topics []Topic, file string, cw map[string]bool) {
+	written := make(map[string]string)
+	f, err := os.Open(file)
+	if err == nil {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			ln := strings.TrimSpace(scanner.Text())
+			if ln == "" || strings.HasPrefix(ln, "#") {
+				continue
+			}
+			fields := strings.SplitN(ln, " ", 2)
+			if len(fields) == 2 {
+				written[normalizeId(fields[0])] = strings.TrimSpace(fields[1])
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		panic(err)
+	}
+	for i := range topics {
+		if query, ok := written[topics[i].Id]; ok {
+			topics[i].Boolean = query
+		} else {
+			topics[i].Boolean = booleanTopic(topics[i].Text, cw)
+		}
+	}
+}
+
+// booleanTopic converts a topic to a boolean query, the union of its words
+// common words are dropped, as they are for vectorial queries
+func booleanTopic(text string, cw map[string]bool) string {
+	var words []string
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), splitter) {
+		switch {
+		case cw[w]:
+		case w == "and", w == "or", w == "not":
+		default:
+			words = append(words, w)
+		}
+	}
+	return strings.Join(words, " OR ")
+}
+
+// normalizeId removes spaces and leading zeros from a numeric id
+// CACM qrels write "01" for topic "1" and "0046" for document "46"
+func normalizeId(id string) string {
+	id = strings.TrimSpace(id)
+	if n, err := strconv.Atoi(id); err == nil {
+		return strconv.Itoa(n)
+	}
+	return id
+}
+
+// ReadQrels reads a relevance judgments file, in the CACM or TREC layout
+// it returns for each topic the grade of its judged documents numbers
+func ReadQrels(file string) map[string]map[string]int {
+	f, err := os.Open(file)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	return readQrels(f)
+}
+
+// readQrels reads relevance judgments,
+// CACM lines are "qid docno 0 0" and only list relevant documents
+// TREC lines are "qid iteration docno grade", the grade being 0 for non relevant documents
+// the layout is guessed from the last two columns, they are always 0 for CACM
+func readQrels(r io.Reader) map[string]map[string]int {
+	var lines [][]string
+	cacm := true
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		if fields[2] != "0" || fields[3] != "0" {
+			cacm = false
+		}
+		lines = append(lines, fields)
+	}
+
+	qrels := make(map[string]map[string]int)
+	for _, fields := range lines {
+		topic := normalizeId(fields[0])
+		if qrels[topic] == nil {
+			qrels[topic] = make(map[string]int)
+		}
+		if cacm {
+			qrels[topic][normalizeId(fields[1])] = 1
+			continue
+		}
+		grade, err := strconv.Atoi(fields[3])
+		if err != nil {
+			continue
+		}
+		// negative grades are used for unjudgeable documents, they count as non relevant
+		if grade < 0 {
+			grade = 0
+		}
+		qrels[topic][fields[2]] = grade
+	}
+	return qrels
+}
+
+// resolveJudgments converts the documents numbers of judgments to ids of the search
+// documents which aren't in the index are dropped
+func resolveJudgments(judged map[string]int, ids map[string]int) map[int]int {
+	resolved := make(map[int]int, len(judged))
+	for no, grade := range judged {
+		if id, ok := ids[no]; ok {
+			resolved[id] = grade
+		}
+	}
+	return resolved
+}
+
+// TopicQuery effects a vector query for a topic
+// the authors of the topic are searched in the author field of the documents
+func TopicQuery(s *Search, topic Topic, wf weight) []Ref {
+	terms := queryTerms(s, topic.Text)
+	for _, author := range topic.Authors {
+		if term := authorTerm(author); term != "" {
+			terms = append(terms, term)
+		}
+	}
+	return rankTerms(s, terms, wf, func(w string, df int) float64 {
+		return s.idf(df)
+	})
+}
+
+// WriteRun writes the results of a weight function for all topics in the TREC run format
+// "qid Q0 docno rank score tag"
+func WriteRun(w io.Writer, search *Search, topics []Topic, wf weight, tag string) {
+	for _, topic := range topics {
+		refs := TopicQuery(search, topic, wf)
+		if len(refs) > runDepth {
+			refs = refs[:runDepth]
+		}
+		for rank, ref := range refs {
+			fmt.Fprintf(w, "%s Q0 %s %d %.6f %s\n",
+				topic.Id, search.externalId(ref.Id), rank+1, ref.Weights[wf], tag)
+		}
+	}
+}
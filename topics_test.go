@@ -0,0 +1,79 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadTRECTopics(t *testing.T) {
+	topics := readTRECTopics(strings.NewReader(`
+<top>
+<num> Number: 051
+<title> Topic: Airbus Subsidies
+
+<desc> Description:
+Document will discuss government assistance to Airbus.
+
+<narr> Narrative:
+To be relevant, a document must cite or discuss assistance.
+</top>
+
+<top>
+<num> Number: 302 </num>
+<title> Poliomyelitis and Post-Polio </title>
+</top>
+`))
+	expected := []Topic{
+		{Id: "51", Text: "Airbus Subsidies Document will discuss government assistance to Airbus."},
+		{Id: "302", Text: "Poliomyelitis and Post-Polio"},
+	}
+	if len(topics) != len(expected) {
+		t.Fatalf("Found %d topics, expected %d", len(topics), len(expected))
+	}
+	for i := range expected {
+		if topics[i].Id != expected[i].Id || topics[i].Text != expected[i].Text {
+			t.Errorf("Topic %d is %q, expected %q", i, topics[i], expected[i])
+		}
+	}
+}
+
+func TestReadCACMTopics(t *testing.T) {
+	topics := readCACMTopics(strings.NewReader(`.I 1
+.W
+ What articles exist which deal with TSS (Time Sharing System)?
+.N
+ 1. Richard Alexander, Comp Serv, Langmuir Lab (TSS)
+
+.I 2
+.W
+ I am interested in articles written either by Prieve or Udo Pooch
+.A
+Prieve, B.
+Pooch, U.
+.N
+ 2. Richard Alexander (author = Pooch or Prieve)
+`))
+	if len(topics) != 2 || topics[1].Id != "2" {
+		t.Fatalf("Topics read as %v", topics)
+	}
+	if len(topics[0].Authors) != 0 || topics[0].Notes != "1. Richard Alexander, Comp Serv, Langmuir Lab (TSS)" {
+		t.Errorf("First topic read as %+v", topics[0])
+	}
+	authors := topics[1].Authors
+	if len(authors) != 2 || authorTerm(authors[0]) != "author:prieve" || authorTerm(authors[1]) != "author:pooch" {
+		t.Errorf("Authors read as %v", authors)
+	}
+}
+
+func TestReadQrels(t *testing.T) {
+	cacm := readQrels(strings.NewReader("01 1410  0 0\n01 0046  0 0\n03 0005  0 0\n"))
+	if len(cacm) != 2 || cacm["1"]["1410"] != 1 || cacm["1"]["46"] != 1 || cacm["3"]["5"] != 1 {
+		t.Errorf("CACM qrels read as %v", cacm)
+	}
+
+	trec := readQrels(strings.NewReader("051 0 AP880212-0161 2\n051 0 AP880216-0139 0\n"))
+	grade, judged := trec["51"]["AP880216-0139"]
+	if trec["51"]["AP880212-0161"] != 2 || !judged || grade != 0 {
+		t.Errorf("TREC qrels read as %v", trec)
+	}
+}
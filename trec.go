@@ -0,0 +1,296 @@
+// Trec.go implements the corpora of TREC collections, SGML files of documents written
+//
+//	<DOC>
+//	<DOCNO> AP880212-0001 </DOCNO>
+//	<HEAD>Reports Former Saigon Officials Released</HEAD>
+//	<TEXT> ... </TEXT>
+//	</DOC>
+//
+// a file, possibly gzipped, holds many documents; the source is a file or a folder of them
+// the DOCNO of a document is its external id, so the documents of TREC qrels are found by eval
+// results are titled by the headline, or the DOCNO when there is none
+// the words of the headline (HEAD, HEADLINE, HL, TITLE or TI) and of the TEXT elements are indexed
+//
+// files are split in documents by one goroutine, documents are indexed by goroutineNumber workers
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+func init() {
+	registerCorpus("trec", func(name, source string) (Corpus, error) {
+		if source == "" {
+			return nil, fmt.Errorf("trec corpus %s has no source", name)
+		}
+		return trecCorpus{name: name, source: source}, nil
+	})
+}
+
+// trecHeadlines are the elements holding the headline of a document, depending of the collection
+var trecHeadlines = []string{"HEADLINE", "HEAD", "HL", "TITLE", "TI"}
+
+// sgmlTag matches the tags nested in the content of an element
+var sgmlTag = regexp.MustCompile(`<[^>]*>`)
+
+// trecDoc is a document of a trec collection
+type trecDoc struct {
+	DocNo    string
+	Headline string
+	Date     string
+	Text     string
+}
+
+// parseTrecDoc parses the content of a <DOC> element, element names are case insensitive
+// elements of the same name are joined, the first headline and date found are kept
+func parseTrecDoc(content string) trecDoc {
+	var doc trecDoc
+	var text []string
+	upper := string(asciiUpper([]byte(content)))
+	for i := 0; ; {
+		lt := strings.IndexByte(content[i:], '<')
+		if lt < 0 {
+			break
+		}
+		lt += i
+		gt := strings.IndexByte(content[lt:], '>')
+		if gt < 0 {
+			break
+		}
+		gt += lt
+		i = gt + 1
+		fields := strings.Fields(upper[lt+1 : gt])
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "/") || fields[0] == "DOC" {
+			continue
+		}
+		name := fields[0]
+		end := strings.Index(upper[gt+1:], "</"+name+">")
+		if end < 0 {
+			continue
+		}
+		value := content[gt+1 : gt+1+end]
+		i = gt + 1 + end + len(name) + 3
+		value = strings.TrimSpace(html.UnescapeString(sgmlTag.ReplaceAllString(value, " ")))
+		switch {
+		case name == "DOCNO":
+			doc.DocNo = value
+		case name == "TEXT":
+			text = append(text, value)
+		case name == "DATE" && doc.Date == "":
+			doc.Date = value
+		case doc.Headline == "" && isTrecHeadline(name):
+			doc.Headline = collapseSpaces(value)
+		}
+	}
+	doc.Text = strings.Join(text, "\n\n")
+	return doc
+}
+
+// asciiUpper returns a copy of b with ascii letters in upper case and the other bytes unchanged
+// so positions in the copy are the ones in b, files aren't always valid utf-8
+func asciiUpper(b []byte) []byte {
+	upper := make([]byte, len(b))
+	for i, c := range b {
+		if c >= 'a' && c <= 'z' {
+			c = c - 'a' + 'A'
+		}
+		upper[i] = c
+	}
+	return upper
+}
+
+func isTrecHeadline(name string) bool {
+	for _, h := range trecHeadlines {
+		if name == h {
+			return true
+		}
+	}
+	return false
+}
+
+// trecDate returns the date of a trec document as stored by documentDate, 0 if it can't be parsed
+// collections write it either as a record date or as yymmdd, e.g 880212 in AP
+func trecDate(value string) int {
+	if date := parseDate(value); date != 0 {
+		return date
+	}
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return 0
+	}
+	if t, err := time.Parse("060102", fields[0]); err == nil {
+		return t.Year()*100 + int(t.Month())
+	}
+	return 0
+}
+
+// trecCorpus is a corpus of trec collection files
+type trecCorpus struct {
+	name, source string
+}
+
+func (c trecCorpus) Name() string   { return c.name }
+func (c trecCorpus) Kind() string   { return "trec" }
+func (c trecCorpus) Source() string { return c.source }
+func (c trecCorpus) Perf() Perf     { return Perf{Initial: sourceSize(c.source)} }
+
+func (c trecCorpus) Scanner(source string, cw map[string]bool, trie *Root) (Scanner, error) {
+	info, err := os.Stat(source)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{source}
+	if info.IsDir() {
+		files = nil
+		err = filepath.Walk(source, func(p string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() && !strings.HasPrefix(info.Name(), ".") {
+				files = append(files, p)
+			}
+			return err
+		})
+		sort.Strings(files)
+	}
+	return &TrecScanner{files: files, cw: cw, trie: trie, toScan: make(chan string, 100)}, err
+}
+
+// Text returns the headline and text of a stored document
+func (c trecCorpus) Text(stored []byte) string {
+	doc := parseTrecDoc(string(stored))
+	return strings.TrimSpace(doc.Headline + "\n" + doc.Text)
+}
+
+func (c trecCorpus) Analyze(word string) string { return stem(word) }
+
+// Page returns the text of a document, titled by its headline
+func (c trecCorpus) Page(s *Search, id int) (string, interface{}, error) {
+	stored, err := s.document(id)
+	if err != nil {
+		return "", nil, err
+	}
+	doc := parseTrecDoc(string(stored))
+	title := doc.DocNo
+	if doc.Headline != "" {
+		title += " - " + doc.Headline
+	}
+	return "doc", textDoc{Title: title, Text: doc.Text}, nil
+}
+
+// TrecScanner indexes the documents of trec collection files
+type TrecScanner struct {
+	files  []string
+	cw     map[string]bool
+	trie   *Root
+	toScan chan string
+}
+
+// Scan sends the scanned documents to the channel using multiple goroutines to index them
+func (s *TrecScanner) Scan(ctx context.Context, c chan metadata) {
+	// goroutine splitting the files in documents
+	go func() {
+		for _, file := range s.files {
+			if ctx.Err() != nil {
+				break
+			}
+			if err := s.split(ctx, file); err != nil {
+				log.Printf("%s: %v\n", file, err)
+			}
+		}
+		close(s.toScan)
+	}()
+	scanConcurrently(ctx, c, s.toScan, s.trie, s.index)
+}
+
+// split sends the <DOC> elements of a file to be indexed, until ctx is cancelled
+// the file is read line by line, only the document being split is kept in memory
+// <DOC> and </DOC> are found whatever their case, as the other element names
+func (s *TrecScanner) split(ctx context.Context, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	if magic, _ := r.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = bufio.NewReader(gz)
+	}
+	// text is the document being split, upper the same bytes in upper case
+	var text, upper []byte
+	var inDoc bool
+	// read is the position of text in the file
+	var read int
+	for ctx.Err() == nil {
+		line, err := r.ReadBytes('\n')
+		text = append(text, line...)
+		upper = append(upper, asciiUpper(line)...)
+		for {
+			if !inDoc {
+				start := bytes.Index(upper, []byte("<DOC>"))
+				if start < 0 {
+					// tags don't span lines, nothing before a <DOC> is kept
+					read += len(text)
+					text, upper = text[:0], upper[:0]
+					break
+				}
+				read += start
+				text, upper = text[start:], upper[start:]
+				inDoc = true
+			}
+			end := bytes.Index(upper, []byte("</DOC>"))
+			if end < 0 {
+				break
+			}
+			end += len("</DOC>")
+			s.toScan <- string(text[:end])
+			read += end
+			text, upper = text[end:], upper[end:]
+			inDoc = false
+		}
+		if err == io.EOF {
+			if inDoc {
+				return fmt.Errorf("document without end at %d", read)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// index reads a trec document in doc, the text stored is the document as written in the file
+func (s *TrecScanner) index(doc *Document, text string) error {
+	d := parseTrecDoc(text)
+	if d.DocNo == "" {
+		return fmt.Errorf("document without DOCNO: %.50q", text)
+	}
+	doc.External = d.DocNo
+	doc.Title = d.Headline
+	if doc.Title == "" {
+		doc.Title = d.DocNo
+	}
+	doc.addText(d.Headline, titlePrefix, s.cw)
+	doc.addText(d.Text, "", s.cw)
+	doc.Date = trecDate(d.Date)
+	doc.Text = []byte(text)
+	doc.Read = len(text)
+	return nil
+}
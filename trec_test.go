@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testTrec = `<DOC>
+<DOCNO> AP880212-0001 </DOCNO>
+<FILEID>AP-NR-02-12-88 2344EST</FILEID>
+<HEAD>Compiler construction</HEAD>
+<TEXT>
+A <P>compiler</P> for an algebraic language &amp; its grammar.
+</TEXT>
+</DOC>
+<DOC>
+<DOCNO> AP880212-0002 </DOCNO>
+<date>880212</date>
+<text>Sorting records on tapes</text>
+</DOC>
+`
+
+func TestTrecCorpus(t *testing.T) {
+	useTempIndexDir(t)
+	dir, err := ioutil.TempDir("", "trec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte(testTrec))
+	w.Close()
+	ioutil.WriteFile(filepath.Join(dir, "ap880212.gz"), gz.Bytes(), 0644)
+
+	corpus, err := makeCorpus("test", "trec", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	search := ParseCorpus(context.Background(), corpus, map[string]bool{})
+	if search.Size != 2 {
+		t.Fatalf("%d documents indexed", search.Size)
+	}
+	refs := search.BooleanSearch("title:compiler AND grammar")
+	if len(refs) != 1 || search.externalId(refs[0].Id) != "AP880212-0001" {
+		t.Fatalf("Query returned %v", refs)
+	}
+	if r := search.result(refs[0].Id); r.Name != "Compiler construction" || r.Url != "/test/AP880212-0001" {
+		t.Errorf("Result is %v", r)
+	}
+	ids := search.docIDs()
+	if id, ok := ids["AP880212-0002"]; !ok || search.date(id) != 198802 {
+		t.Errorf("Document numbers are %v", ids)
+	}
+	text, _ := search.document(refs[0].Id)
+	if corpus.Text(text) != "Compiler construction\nA  compiler  for an algebraic language & its grammar." {
+		t.Errorf("Text is %q", corpus.Text(text))
+	}
+}
+
+func TestTrecSplit(t *testing.T) {
+	// lower case tags, two documents on a line and text between documents
+	file := filepath.Join(t.TempDir(), "fr940104")
+	ioutil.WriteFile(file, []byte("<doc><docno>FR-1</docno></doc> <Doc><DOCNO>FR-2</DOCNO>\n<text>rules</text>\n</DOC>\nignored\n<doc>\n<docno>FR-3</docno>\n"), 0644)
+	s := &TrecScanner{toScan: make(chan string, 10)}
+	err := s.split(context.Background(), file)
+	close(s.toScan)
+	var docNos []string
+	for text := range s.toScan {
+		docNos = append(docNos, parseTrecDoc(text).DocNo)
+	}
+	if len(docNos) != 2 || docNos[0] != "FR-1" || docNos[1] != "FR-2" {
+		t.Errorf("Documents split %v", docNos)
+	}
+	// the last document has no end, its position is reported
+	if err == nil || err.Error() != "document without end at 90" {
+		t.Errorf("Split returned %v", err)
+	}
+}
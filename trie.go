@@ -6,6 +6,7 @@ package main
 
 import (
 	"math"
+	"sort"
 	"strings"
 	"sync"
 )
@@ -17,6 +18,12 @@ type Root struct {
 	// the count is protected by a lock
 	mu    sync.Mutex
 	count int
+	// spill writes the trie to disk when it outgrows the memory budget, see spimi.go
+	// flushing is held for reading while a document is added, for writing while the trie is written
+	spill    *spill
+	flushing sync.RWMutex
+	// locals are the private indexes of the workers, merged in the trie once the scan is done, see local.go
+	locals []*localIndex
 }
 
 // Node implements a node of the tree
@@ -35,15 +42,45 @@ func NewTrie() *Root {
 	return &Root{Node: &Node{}}
 }
 
+// newTrieFrom returns an empty trie whose document ids start at first
+// it's used for segments added after the initial indexing
+func newTrieFrom(first int) *Root {
+	return &Root{Node: &Node{}, count: first}
+}
+
 // addDoc adds all a document references to the trie
 // It also generates the document ID
 func (r *Root) addDoc(doc *Document) {
+	r.addDocInTurn(doc, turn{})
+}
+
+// addDocInTurn adds a document once the documents read before it have their ids, see turn
+func (r *Root) addDocInTurn(doc *Document, t turn) {
+	t.wait()
+	r.flushing.RLock()
+	r.newId(doc)
+	t.pass()
+	var nodes int
+	addScores(doc, func(w string, score weights) {
+		nodes += r.add(w, doc.Id, score)
+	})
+	r.flushing.RUnlock()
+	if r.spill != nil {
+		r.spill.grow(r, len(doc.Words), nodes)
+	}
+}
+
+// newId gives the next document id to doc
+func (r *Root) newId(doc *Document) {
 	r.mu.Lock()
 	doc.Id = r.count
 	r.count++
 	r.mu.Unlock()
+}
 
-	// We calculate tf scores just before adding the terms
+// addScores calls add with each term of doc and its weights
+// tf scores are calculated just before adding the terms
+func addScores(doc *Document, add func(w string, score weights)) {
 	// Get the maximun tf
 	var max int
 	for _, s := range doc.Count {
@@ -58,26 +95,53 @@ func (r *Root) addDoc(doc *Document) {
 		score[raw] = tf
 		score[norm] = 1 + math.Log(tf)
 		score[half] = 0.5 + 0.5*tf*maxF
-		r.add(doc.Words[i], doc.Id, score)
+		add(doc.Words[i], score)
 	}
 }
 
 // add the weights and id to w
-func (r *Root) add(w string, id int, tfidf weights) {
+// it returns the number of nodes created, to estimate the size of the trie
+func (r *Root) add(w string, id int, tfidf weights) int {
+	cur, created := r.node(w)
+	ref := Ref{id, tfidf}
+	cur.rw.Lock()
+	idx := getMatchingRef(cur.Refs, ref.Id)
+	cur.Refs = append(cur.Refs, ref)
+	copy(cur.Refs[idx+1:], cur.Refs[idx:])
+	cur.Refs[idx] = ref
+	cur.rw.Unlock()
+	return created
+}
+
+// addRefs adds refs, sorted by id, to w
+func (r *Root) addRefs(w string, refs []Ref) {
+	cur, _ := r.node(w)
+	cur.rw.Lock()
+	if len(cur.Refs) == 0 {
+		cur.Refs = refs
+	} else {
+		cur.Refs = append(cur.Refs, refs...)
+		sortById(cur.Refs)
+	}
+	cur.rw.Unlock()
+}
+
+// sortById sorts refs by increasing id
+func sortById(refs []Ref) {
+	sort.Slice(refs, func(i, j int) bool { return refs[i].Id < refs[j].Id })
+}
+
+// node returns the node of w, it's created with the nodes on its path if needed
+// it returns the number of nodes created too
+func (r *Root) node(w string) (*Node, int) {
 	// descends the tree to find the proper leaf
 	cur := r.Node             // node we are exploring
 	var shared, i, length int // shared: part of w already matched
 	rad := ""                 // buffer for radix
-	ref := Ref{id, tfidf}
+	var created int
 	for {
 		if shared == len(w) {
-			cur.rw.Lock()
-			idx := getMatchingRef(cur.Refs, ref.Id)
-			cur.Refs = append(cur.Refs, ref)
-			copy(cur.Refs[idx+1:], cur.Refs[idx:])
-			cur.Refs[idx] = ref
-			cur.rw.Unlock()
-			return
+			return cur, created
 		}
 	MainInsert:
 		cur.rw.RLock()
@@ -113,6 +177,7 @@ func (r *Root) add(w string, id int, tfidf weights) {
 			// insert the new node in place
 			cur.Radix[i] = rad[:size]
 			cur.Sons[i] = new
+			created++
 			// keep iterating on the new node
 			cur.rw.Unlock()
 			cur = new
@@ -128,9 +193,7 @@ func (r *Root) add(w string, id int, tfidf weights) {
 				goto MainInsert
 			}
 			// No son share a common prefix
-			new := &Node{
-				Refs: []Ref{ref},
-			}
+			new := &Node{}
 			cur.Sons = append(cur.Sons, new)
 			cur.Radix = append(cur.Radix, "")
 			copy(cur.Sons[i+1:], cur.Sons[i:])
@@ -139,7 +202,7 @@ func (r *Root) add(w string, id int, tfidf weights) {
 			cur.Sons[i] = new
 			cur.Radix[i] = w[shared:]
 			cur.rw.Unlock()
-			break
+			return new, created + 1
 		}
 	}
 }
@@ -176,25 +239,22 @@ func (r *Root) buildRef(in []Ref) []Ref {
 	return out
 }
 
-// calculateIDF calculateIDF in a concurrent maner
-func (r *Root) calculateIDF(size int) {
-	factor := float64(size)
-	for _, son := range r.Node.Sons {
-		go son.calculateIDF(factor)
-	}
+// walk calls f for every word of the trie, in lexical order
+// f must not keep the refs, they are not copied
+func (r *Root) walk(f func(w string, refs []Ref)) {
+	r.Node.walk("", f)
 }
 
-// calculateIDF walks through the tree calculating IDF for all nodes
-func (n *Node) calculateIDF(factor float64) {
+// walk calls f for every word under the node, prefix being the word leading to n
+func (n *Node) walk(prefix string, f func(w string, refs []Ref)) {
 	n.rw.RLock()
-	idf := math.Log(factor / float64(len(n.Refs)))
-	for i := range n.Refs {
-		scale(&n.Refs[i].Weights, idf)
+	defer n.rw.RUnlock()
+	if len(n.Refs) > 0 {
+		f(prefix, n.Refs)
 	}
-	for _, son := range n.Sons {
-		son.calculateIDF(factor)
+	for i, son := range n.Sons {
+		son.walk(prefix+n.Radix[i], f)
 	}
-	n.rw.RUnlock()
 }
 
 // getInfIndex walks the tree
@@ -237,6 +297,50 @@ func (n *Node) getAverageSonsCount() (int, int) {
 	return sons, count
 }
 
+// trieShape holds statistics about the structure of a trie
+type trieShape struct {
+	Nodes    int
+	Leaves   int
+	Terms    int
+	Postings int
+	// Depth is the number of nodes at each depth, the root being at depth 0
+	Depth []int
+	// FanOut is the number of nodes having each number of sons
+	FanOut []int
+}
+
+// shape walks the tree and returns its statistics
+func (r *Root) shape() trieShape {
+	var st trieShape
+	r.Node.shape(0, &st)
+	return st
+}
+
+// shape adds the statistics of the node and its descendants to st
+func (n *Node) shape(depth int, st *trieShape) {
+	n.rw.RLock()
+	defer n.rw.RUnlock()
+	st.Nodes++
+	if len(n.Sons) == 0 {
+		st.Leaves++
+	}
+	if len(n.Refs) > 0 {
+		st.Terms++
+		st.Postings += len(n.Refs)
+	}
+	for len(st.Depth) <= depth {
+		st.Depth = append(st.Depth, 0)
+	}
+	st.Depth[depth]++
+	for len(st.FanOut) <= len(n.Sons) {
+		st.FanOut = append(st.FanOut, 0)
+	}
+	st.FanOut[len(n.Sons)]++
+	for _, son := range n.Sons {
+		son.shape(depth+1, st)
+	}
+}
+
 // longestPrefixSize returns the longest prefix of rad and w
 // with shared being the already matched part of w
 // and assuming rad[0] == w[shared]
@@ -294,8 +398,8 @@ func getMatchingRef(refs []Ref, id int) int {
 	// Walk from the end as it's likely to be more efficient
 	for i := len(refs) - 1; i >= 0; i-- {
 		if refs[i].Id < id {
-			return i
+			return i + 1
 		}
 	}
-	return len(refs)
+	return 0
 }
@@ -83,3 +83,16 @@ func TestTrie(t *testing.T) {
 		}
 	}
 }
+
+func TestTrieRefsOrder(t *testing.T) {
+	trie := NewTrie()
+	for _, id := range []int{3, 0, 4, 1, 2} {
+		trie.add("chromosome", id, weights{})
+	}
+	refs := trie.get("chromosome")
+	for i, ref := range refs {
+		if ref.Id != i {
+			t.Fatal("Refs are not ordered by id")
+		}
+	}
+}
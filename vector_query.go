@@ -2,11 +2,9 @@ package main
 
 import (
 	"sort"
-	"strings"
 	"unicode"
 
 	"github.com/gonum/floats"
-	"github.com/surgebase/porter2"
 )
 
 // splitter is a rules by which words are splitted
@@ -15,9 +13,18 @@ func splitter(c rune) bool {
 	return !unicode.IsLetter(c) && !unicode.IsNumber(c)
 }
 
+// querySplitter splits queries, it keeps ':' and '.' so "field:value" words can be recognized
+// see queryWords
+func querySplitter(c rune) bool {
+	return c != ':' && c != '.' && splitter(c)
+}
+
 // mergeWithTfIdf calculate the merge of a sorted list of documents
 // calculating the norm in the sametime
 func mergeWithTfIdf(documents [][]Ref, wf weight) []Ref {
+	if len(documents) == 0 {
+		return []Ref{}
+	}
 	merge := make([]Ref, 0, len(documents[0]))
 	// Temporaty slice to store result
 	temps := make([]float64, 0, len(documents))
@@ -54,18 +61,66 @@ func mergeWithTfIdf(documents [][]Ref, wf weight) []Ref {
 
 // VectorQuery effects a vector query on a search object
 func VectorQuery(s *Search, input string, wf weight) []Ref {
-	words := strings.FieldsFunc(input, splitter)
-	documents := make([][]Ref, len(words))
-	for i, w := range words {
+	return vectorQuery(s, input, wf, func(w string, df int) float64 {
+		return s.idf(df)
+	})
+}
+
+// queryTerms returns the terms of a query as they are stored in the index
+// "field:value" words are searched in their field only, other words are searched everywhere
+// and in the boosted fields
+func queryTerms(s *Search, input string) []string {
+	words := queryWords(input)
+	terms := make([]string, 0, len(words))
+	for _, w := range words {
+		if term, ok := fieldTerm(w); ok {
+			if term != "" {
+				terms = append(terms, term)
+			}
+			continue
+		}
 		if s.CW[w] {
 			continue
 		}
-		if len(w) > 3 {
-			w = porter2.Stem(w)
+		w = s.analyze(w)
+		terms = append(terms, w)
+		for _, fb := range fieldBoosts {
+			terms = append(terms, fb.Prefix+w)
 		}
-		documents[i] = s.Index.get(w)
+	}
+	return terms
+}
+
+// vectorQuery effects a vector query with idf returning the idf of a term
+// from its local document frequency, shards use it to apply global statistics
+// year ranges of the query filter the ranked documents
+func vectorQuery(s *Search, input string, wf weight, idf func(w string, df int) float64) []Ref {
+	ranges, _ := yearRanges(queryWords(input))
+	return s.filterDates(rankTerms(s, queryTerms(s, input), wf, idf), ranges)
+}
+
+// rankTerms returns the documents containing terms, ordered by their score
+func rankTerms(s *Search, terms []string, wf weight, idf func(w string, df int) float64) []Ref {
+	documents := make([][]Ref, len(terms))
+	for i, w := range terms {
+		refs := s.get(w)
+		// tf are stored in the index, idf is applied with the current document count
+		termIDF := idf(w, len(refs)) * termBoost(w)
+		for j := range refs {
+			scale(&refs[j].Weights, termIDF)
+		}
+		documents[i] = refs
 	}
 	results := mergeWithTfIdf(documents, wf)
+	if staticScore != "" {
+		s.mixStatic(results, wf, staticScore, staticMix)
+	}
+	sortRefs(results, wf)
+	return results
+}
+
+// sortRefs sorts refs by decreasing wf weight
+func sortRefs(results []Ref, wf weight) {
 	if wf == raw {
 		sort.Sort(rawList(results))
 	} else if wf == norm {
@@ -73,7 +128,6 @@ func VectorQuery(s *Search, input string, wf weight) []Ref {
 	} else if wf == half {
 		sort.Sort(halfList(results))
 	}
-	return results
 }
 
 // Define a custom type to add custom method
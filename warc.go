@@ -0,0 +1,374 @@
+// Warc.go implements the corpora of web archives, WARC files possibly gzipped
+//
+//	-corpora crawl=warc:data/crawl?max=100000
+//
+// the source is a .warc or .warc.gz file, or a folder of them
+// records are read one by one, only the responses holding html pages are indexed
+// the target uri of a response is the url of the page and its external id, results link to it
+//
+// scans can be resumed: the offset reached in each file is saved with the index, see Search.Resume
+// and the next scan of the same files, by -add, starts from it; max bounds the records read by a scan
+// so a large crawl can be indexed in several runs, "-index" then "-add crawl:data/crawl" until nothing is left
+// gzipped archives are resumed at the start of a gzip member, so their members are read whole
+// crawlers write a member per record, an archive compressed as a single member is read at once
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	registerCorpus("warc", func(name, source string) (Corpus, error) {
+		if source == "" {
+			return nil, fmt.Errorf("warc corpus %s has no source", name)
+		}
+		path, max, err := parseWARCSource(source)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		return warcCorpus{name: name, source: source, path: path, max: max}, nil
+	})
+}
+
+// parseWARCSource splits a source in its path and the maximum number of records read, "path?max=1000"
+// max is 0 when there is no limit
+func parseWARCSource(source string) (string, int64, error) {
+	path, options := source, ""
+	if i := strings.Index(source, "?"); i >= 0 {
+		path, options = source[:i], source[i+1:]
+	}
+	values, err := url.ParseQuery(options)
+	if err != nil {
+		return "", 0, err
+	}
+	var max int64
+	for key, v := range values {
+		if key != "max" {
+			return "", 0, fmt.Errorf("unknown warc option %s", key)
+		}
+		if max, err = strconv.ParseInt(v[0], 10, 64); err != nil || max < 0 {
+			return "", 0, fmt.Errorf("max %q isn't a number of records", v[0])
+		}
+	}
+	return path, max, nil
+}
+
+// warcCorpus is a corpus of WARC archives
+type warcCorpus struct {
+	name, source string
+	path         string
+	max          int64
+}
+
+func (c warcCorpus) Name() string   { return c.name }
+func (c warcCorpus) Kind() string   { return "warc" }
+func (c warcCorpus) Source() string { return c.source }
+func (c warcCorpus) Perf() Perf     { return Perf{Initial: sourceSize(c.path)} }
+
+// Scanner returns a scanner of the archives at source
+// sources of added archives without max use the one of the corpus
+func (c warcCorpus) Scanner(source string, cw map[string]bool, trie *Root) (Scanner, error) {
+	path, max := source, c.max
+	if strings.Contains(source, "?") {
+		var err error
+		if path, max, err = parseWARCSource(source); err != nil {
+			return nil, err
+		}
+	}
+	files, err := warcFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	return &WARCScanner{
+		files:  files,
+		max:    max,
+		read:   make(map[string]int64),
+		cw:     cw,
+		trie:   trie,
+		toScan: make(chan warcRecord, 100),
+	}, nil
+}
+
+func (c warcCorpus) Text(stored []byte) string  { return pageText(stored) }
+func (c warcCorpus) Analyze(word string) string { return stem(word) }
+
+func (c warcCorpus) Page(s *Search, id int) (string, interface{}, error) {
+	return pageDocument(s, id)
+}
+
+// OriginalUrl returns the target uri of the response of the document
+func (c warcCorpus) OriginalUrl(s *Search, id int) string {
+	stored, err := s.document(id)
+	if err != nil {
+		return ""
+	}
+	var p page
+	json.Unmarshal(stored, &p)
+	return p.Url
+}
+
+// warcFiles returns the absolute paths of the archives at path, path itself or the archives under it
+// absolute paths identify the files in the positions of resumed scans
+func warcFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	if !info.IsDir() {
+		files = []string{path}
+	} else {
+		err = filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() && (strings.HasSuffix(p, ".warc") || strings.HasSuffix(p, ".warc.gz")) {
+				files = append(files, p)
+			}
+			return err
+		})
+	}
+	for i, f := range files {
+		if files[i], err = filepath.Abs(f); err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(files)
+	return files, err
+}
+
+// warcRecord is a response record of an archive
+type warcRecord struct {
+	uri  string
+	date string
+	// block is the http response
+	block []byte
+}
+
+// WARCScanner indexes the html responses of WARC archives
+type WARCScanner struct {
+	files []string
+	// max is the maximum number of records read by a scan, no limit when 0
+	max int64
+	// skip are the offsets reached by previous scans in each file
+	// read the offsets reached by this scan
+	skip map[string]int64
+	read map[string]int64
+	cw   map[string]bool
+	trie *Root
+	// toScan are the responses to index
+	toScan chan warcRecord
+}
+
+func (s *WARCScanner) resume(positions map[string]int64) {
+	s.skip = positions
+}
+
+func (s *WARCScanner) positions() map[string]int64 {
+	positions := make(map[string]int64)
+	// files of previous scans which weren't read this time are kept
+	for file, n := range s.skip {
+		positions[file] = n
+	}
+	for file, n := range s.read {
+		positions[file] = n
+	}
+	return positions
+}
+
+// Scan sends the scanned pages to the channel using multiple goroutines to index them
+func (s *WARCScanner) Scan(ctx context.Context, c chan metadata) {
+	// goroutine reading the records of the files in order
+	go func() {
+		var total int64
+		for _, file := range s.files {
+			if (s.max > 0 && total >= s.max) || ctx.Err() != nil {
+				break
+			}
+			n, err := s.readFile(ctx, file, s.max-total)
+			if err != nil {
+				log.Printf("%s: %v\n", file, err)
+			}
+			total += n
+		}
+		close(s.toScan)
+	}()
+	scanConcurrently(ctx, c, s.toScan, s.trie, s.index)
+}
+
+// countingReader counts the bytes read from r
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// readFile sends the responses of an archive from the offset reached by previous scans, up to limit records if positive
+// it returns the number of records read, it stops when ctx is cancelled
+func (s *WARCScanner) readFile(ctx context.Context, file string, limit int64) (int64, error) {
+	start := s.skip[file]
+	s.read[file] = start
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(start, io.SeekStart); err != nil {
+		return 0, err
+	}
+	counter := &countingReader{r: f}
+	r := bufio.NewReader(counter)
+	// offset is the position in the file of the next byte of r
+	offset := func() int64 { return start + counter.n - int64(r.Buffered()) }
+	var n int64
+	send := func(header textproto.MIMEHeader, block []byte) {
+		n++
+		if header.Get("WARC-Type") == "response" {
+			s.toScan <- warcRecord{
+				uri:   strings.Trim(header.Get("WARC-Target-URI"), "<>"),
+				date:  header.Get("WARC-Date"),
+				block: block,
+			}
+		}
+	}
+
+	if magic, _ := r.Peek(2); len(magic) != 2 || magic[0] != 0x1f || magic[1] != 0x8b {
+		for (limit <= 0 || n < limit) && ctx.Err() == nil {
+			header, block, err := readWARCRecord(r)
+			if err == io.EOF {
+				return n, nil
+			}
+			if err != nil {
+				return n, err
+			}
+			send(header, block)
+			s.read[file] = offset()
+		}
+		return n, nil
+	}
+	// the gzip members are read one by one, r being a byte reader gzip doesn't read past them
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return 0, err
+	}
+	defer gz.Close()
+	for {
+		gz.Multistream(false)
+		member := bufio.NewReader(gz)
+		for {
+			header, block, err := readWARCRecord(member)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return n, err
+			}
+			send(header, block)
+		}
+		s.read[file] = offset()
+		if (limit > 0 && n >= limit) || ctx.Err() != nil {
+			return n, nil
+		}
+		if err := gz.Reset(r); err == io.EOF {
+			return n, nil
+		} else if err != nil {
+			return n, err
+		}
+	}
+}
+
+// readWARCRecord reads the next record of an archive, its header and its block
+// it returns io.EOF when there is no record left
+func readWARCRecord(r *bufio.Reader) (textproto.MIMEHeader, []byte, error) {
+	// records are separated by empty lines
+	var version string
+	for version == "" {
+		line, err := r.ReadString('\n')
+		if err == io.EOF && strings.TrimSpace(line) == "" {
+			return nil, nil, io.EOF
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		version = strings.TrimSpace(line)
+	}
+	if !strings.HasPrefix(version, "WARC/") {
+		return nil, nil, fmt.Errorf("record starts with %q instead of the warc version", version)
+	}
+	header, err := textproto.NewReader(r).ReadMIMEHeader()
+	if err != nil {
+		return nil, nil, err
+	}
+	length, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return nil, nil, fmt.Errorf("record has an invalid length: %v", err)
+	}
+	block := make([]byte, length)
+	if _, err := io.ReadFull(r, block); err != nil {
+		return nil, nil, err
+	}
+	return header, block, nil
+}
+
+// index reads a response in the document, responses which aren't html pages aren't documents
+func (s *WARCScanner) index(doc *Document, record warcRecord) error {
+	body, ok := htmlBody(record.block)
+	if !ok {
+		return errNotDocument
+	}
+	p := parsePage(body, record.uri)
+	if p.Title == "" {
+		p.Title = record.uri
+	}
+	if err := indexPage(doc, p, s.cw); err != nil {
+		return fmt.Errorf("%s: %v", record.uri, err)
+	}
+	doc.Date = parseDate(record.date)
+	doc.External = record.uri
+	doc.Read = len(record.block)
+	return nil
+}
+
+// htmlBody returns the body of an http response, false if it isn't a successful html response
+func htmlBody(block []byte) (string, bool) {
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(block)), nil)
+	if err != nil {
+		return "", false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 || !strings.Contains(resp.Header.Get("Content-Type"), "html") {
+		return "", false
+	}
+	var body io.Reader = resp.Body
+	if resp.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			return "", false
+		}
+		defer gz.Close()
+		body = gz
+	}
+	content, err := ioutil.ReadAll(body)
+	if err != nil && len(content) == 0 {
+		return "", false
+	}
+	return string(content), true
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// warcRecordText returns a record of an archive
+func warcRecordText(kind, uri, block string) string {
+	return fmt.Sprintf("WARC/1.0\r\nWARC-Type: %s\r\nWARC-Target-URI: %s\r\nWARC-Date: 2017-03-01T10:00:00Z\r\n"+
+		"Content-Length: %d\r\n\r\n%s\r\n\r\n", kind, uri, len(block), block)
+}
+
+func httpResponse(contentType, body string) string {
+	return fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Type: %s\r\nContent-Length: %d\r\n\r\n%s", contentType, len(body), body)
+}
+
+func TestWARCCorpus(t *testing.T) {
+	useTempIndexDir(t)
+	dir, err := ioutil.TempDir("", "warc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// a gzip member per record, as crawlers write them
+	var archive bytes.Buffer
+	for _, record := range []string{
+		warcRecordText("warcinfo", "", "software: test"),
+		warcRecordText("response", "http://site.org/compiler",
+			httpResponse("text/html", `<title>Compilers</title><p>A compiler for an algebraic language <a href="/tapes">tapes</a></p>`)),
+		warcRecordText("response", "http://site.org/logo.png", httpResponse("image/png", "compiler")),
+		warcRecordText("response", "http://site.org/tapes", httpResponse("text/html; charset=utf-8", `<p>Merging sorted tapes</p>`)),
+	} {
+		gz := gzip.NewWriter(&archive)
+		gz.Write([]byte(record))
+		gz.Close()
+	}
+	file := filepath.Join(dir, "crawl.warc.gz")
+	ioutil.WriteFile(file, archive.Bytes(), 0644)
+
+	// the first scan stops after two records, the next ones resume after them
+	corpus, err := makeCorpus("testwarc", "warc", dir+"?max=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	search := ParseCorpus(context.Background(), corpus, map[string]bool{})
+	refs := search.BooleanSearch("compiler")
+	if search.Size != 1 || len(refs) != 1 || refs[0].Url != "http://site.org/compiler" {
+		t.Fatalf("First scan indexed %d pages, %v", search.Size, refs)
+	}
+	if date := search.date(refs[0].Id); date != 201703 {
+		t.Errorf("Date is %d", date)
+	}
+	search.Serialize()
+	if err := AppendCorpus(context.Background(), search, dir); err != nil {
+		t.Fatal(err)
+	}
+	refs = search.BooleanSearch("merging")
+	if search.Size != 2 || len(refs) != 1 || refs[0].Name != "http://site.org/tapes" {
+		t.Fatalf("Resumed scan indexed %d pages, %v", search.Size, refs)
+	}
+	abs, _ := filepath.Abs(file)
+	if search.Resume[abs] != int64(archive.Len()) {
+		t.Errorf("Positions are %v", search.Resume)
+	}
+	if err := AppendCorpus(context.Background(), search, dir); err != nil || search.Size != 2 {
+		t.Errorf("Records indexed twice: %d pages, %v", search.Size, err)
+	}
+}
+
+func TestWARCPlainResume(t *testing.T) {
+	useTempIndexDir(t)
+	dir := t.TempDir()
+	var archive bytes.Buffer
+	for i := 0; i < 5; i++ {
+		archive.WriteString(warcRecordText("response", fmt.Sprintf("http://site.org/%d", i),
+			httpResponse("text/html", fmt.Sprintf("<p>compiler page%d</p>", i))))
+	}
+	ioutil.WriteFile(filepath.Join(dir, "crawl.warc"), archive.Bytes(), 0644)
+	corpus, err := makeCorpus("testwarc", "warc", dir+"?max=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	search := ParseCorpus(context.Background(), corpus, map[string]bool{})
+	search.Serialize()
+	// each scan starts at the offset reached by the previous one
+	for _, size := range []int{4, 5, 5} {
+		if err := AppendCorpus(context.Background(), search, dir+"?max=2"); err != nil {
+			t.Fatal(err)
+		}
+		if search.Size != size {
+			t.Fatalf("%d pages indexed instead of %d", search.Size, size)
+		}
+	}
+	if refs := search.BooleanSearch("compiler"); len(refs) != 5 || refs[4].Url != "http://site.org/4" {
+		t.Errorf("Pages indexed %v", refs)
+	}
+}